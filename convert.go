@@ -0,0 +1,33 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/leighmcculloch/gate/gate"
+)
+
+// importers convert other tools' files to state, by the name given to --from
+var importers = map[string]func(data []byte) (*gate.State, []string, error){
+	"mrconfig": gate.ImportMrconfig,
+	"repo":     gate.ImportRepoManifest,
+	"vcstool":  gate.ImportVcstool,
+}
+
+// exporters convert state to other tools' files, by the name given to --to
+var exporters = map[string]func(state *gate.State, opts gate.ExportOptions) ([]byte, []string, error){
+	"mrconfig": gate.ExportMrconfig,
+	"repo":     gate.ExportRepoManifest,
+	"sh":       gate.ExportShell,
+	"vcstool":  gate.ExportVcstool,
+}
+
+// converterNames lists the names of converters for messages
+func converterNames[T any](converters map[string]T) string {
+	names := make([]string, 0, len(converters))
+	for name := range converters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/leighmcculloch/gate/gate"
+)
+
+// passphraseEnv is the environment variable passphrases are read from when
+// no passphrase file is given
+const passphraseEnv = "GATE_PASSPHRASE"
+
+// readPassphrase reads a passphrase from the first line of the file at path,
+// or from $GATE_PASSPHRASE when path is empty
+func readPassphrase(path string) (string, error) {
+	if path == "" {
+		return os.Getenv(passphraseEnv), nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	line, _, _ := strings.Cut(string(data), "\n")
+	return strings.TrimSuffix(line, "\r"), nil
+}
+
+// readEncryptOptions returns who output is encrypted for, from recipients
+// given as flags and in recipientsFile, and a passphrase when passphrase is
+// set. It returns nil when output is not encrypted.
+func readEncryptOptions(recipients []string, recipientsFile string, passphrase bool, passphraseFile string) (*gate.EncryptOptions, error) {
+	var opts gate.EncryptOptions
+	for _, s := range recipients {
+		r, err := gate.ParseRecipient(s)
+		if err != nil {
+			return nil, err
+		}
+		opts.Recipients = append(opts.Recipients, r)
+	}
+	if recipientsFile != "" {
+		data, err := os.ReadFile(recipientsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", recipientsFile, err)
+		}
+		fromFile, err := gate.ParseRecipients(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", recipientsFile, err)
+		}
+		opts.Recipients = append(opts.Recipients, fromFile...)
+	}
+	if passphrase {
+		var err error
+		opts.Passphrase, err = readPassphrase(passphraseFile)
+		if err != nil {
+			return nil, err
+		}
+		if opts.Passphrase == "" {
+			return nil, fmt.Errorf("--passphrase needs $%s or --passphrase-file", passphraseEnv)
+		}
+	}
+	if len(opts.Recipients) == 0 && opts.Passphrase == "" {
+		return nil, nil
+	}
+	return &opts, nil
+}
+
+// decryptInput decrypts data read from name with keys when it is encrypted,
+// and reports whether it was
+func decryptInput(data []byte, name string, keys gate.DecryptOptions, logger *slog.Logger) ([]byte, bool, error) {
+	if !gate.IsEncrypted(data) {
+		return data, false, nil
+	}
+	data, err := gate.Decrypt(data, keys)
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to decrypt %s: %w", name, err)
+	}
+	logger.Debug(fmt.Sprintf("decrypted %s", name))
+	return data, true, nil
+}
+
+// readDecryptOptions returns the keys that encrypted input is decrypted
+// with, from identityFiles and the passphrase
+func readDecryptOptions(identityFiles []string, passphraseFile string) (gate.DecryptOptions, error) {
+	var opts gate.DecryptOptions
+	for _, path := range identityFiles {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return opts, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		identities, err := gate.ParseIdentities(data)
+		if err != nil {
+			return opts, fmt.Errorf("%s: %w", path, err)
+		}
+		opts.Identities = append(opts.Identities, identities...)
+	}
+	var err error
+	opts.Passphrase, err = readPassphrase(passphraseFile)
+	return opts, err
+}
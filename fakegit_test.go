@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+
+	"github.com/leighmcculloch/gate/gate"
+)
+
+// fakeRepo is a repository known to fakeBackend
+type fakeRepo struct {
+	remoteURL string
+	branch    string
+	commit    string
+	commits   map[string]bool
+	mainPath  string
+	bare      bool
+	dirty     bool
+	branchErr error
+	commitErr error
+}
+
+// fakeRemote is a remote that fakeBackend can clone and fetch from
+type fakeRemote struct {
+	// cloneErr is returned by every clone of the remote when set
+	cloneErr error
+	// cloneErrs are returned by successive clones of the remote before
+	// cloning succeeds
+	cloneErrs []error
+	// interrupt makes a clone create its directory and then interrupt the
+	// process, as if Ctrl-C was pressed part way through the clone
+	interrupt bool
+	// progress are the percentages a clone reports while receiving objects
+	progress []int
+	// commits are the commits contained in a plain clone
+	commits []string
+	// fetches maps refspecs that can be fetched to the commit they bring in
+	fetches map[string]string
+}
+
+// fakeBackend is an in-memory gate.GitBackend for exercising capture and apply
+// without real repositories. Clones and worktrees create empty directories so
+// the filesystem checks in apply behave as they would after real git commands.
+type fakeBackend struct {
+	repos   map[string]*fakeRepo
+	remotes map[string]*fakeRemote
+	lfs     bool
+	// insteadOf are the url.<base>.insteadOf rules in git's config
+	insteadOf []gate.URLRewrite
+	// calls records every mutating operation in the order it was made
+	calls []string
+}
+
+var _ gate.GitBackend = (*fakeBackend)(nil)
+
+func newFakeBackend() *fakeBackend {
+	return &fakeBackend{
+		repos:   map[string]*fakeRepo{},
+		remotes: map[string]*fakeRemote{},
+	}
+}
+
+// runner returns a testcli main function that runs the CLI with the fake
+func (f *fakeBackend) runner() func(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	return func(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+		return runWithBackend(f, args, stdin, stdout, stderr)
+	}
+}
+
+// addRepo registers a repository at path, which is resolved to an absolute
+// path the same way capture and apply resolve it
+func (f *fakeBackend) addRepo(path string, repo *fakeRepo) {
+	if repo.commits == nil {
+		repo.commits = map[string]bool{}
+	}
+	if repo.commit != "" {
+		repo.commits[repo.commit] = true
+	}
+	f.repos[abs(path)] = repo
+}
+
+func (f *fakeBackend) repo(path string) *fakeRepo {
+	return f.repos[abs(path)]
+}
+
+func (f *fakeBackend) errorf(dir string, args []string, format string, a ...any) error {
+	return &gate.GitError{Args: args, Dir: dir, ExitCode: 128, Stderr: fmt.Sprintf(format, a...)}
+}
+
+func abs(path string) string {
+	p, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	return p
+}
+
+func (f *fakeBackend) IsGitRepo(ctx context.Context, path string) bool {
+	return f.repo(path) != nil
+}
+
+func (f *fakeBackend) IsBareRepo(ctx context.Context, path string) bool {
+	return f.repo(path).bare
+}
+
+func (f *fakeBackend) IsMirror(ctx context.Context, path string) bool {
+	return false
+}
+
+func (f *fakeBackend) IsWorktree(ctx context.Context, path string) (bool, string) {
+	r := f.repo(path)
+	if r.mainPath == "" {
+		return false, ""
+	}
+	rel, err := filepath.Rel(abs(path), abs(r.mainPath))
+	if err != nil {
+		return true, r.mainPath
+	}
+	return true, rel
+}
+
+func (f *fakeBackend) Branch(ctx context.Context, path string) (string, error) {
+	r := f.repo(path)
+	return r.branch, r.branchErr
+}
+
+func (f *fakeBackend) Commit(ctx context.Context, path string) (string, error) {
+	r := f.repo(path)
+	return r.commit, r.commitErr
+}
+
+func (f *fakeBackend) RemoteURL(ctx context.Context, path string) (string, error) {
+	r := f.repo(path)
+	if r.remoteURL == "" {
+		return "", f.errorf(path, []string{"remote", "get-url", "origin"}, "error: No such remote 'origin'")
+	}
+	return r.remoteURL, nil
+}
+
+func (f *fakeBackend) InsteadOf(ctx context.Context) ([]gate.URLRewrite, error) {
+	return f.insteadOf, nil
+}
+
+func (f *fakeBackend) HasUncommittedChanges(ctx context.Context, path string) (bool, error) {
+	return f.repo(path).dirty, nil
+}
+
+func (f *fakeBackend) ShallowDepth(ctx context.Context, path string) int {
+	return 0
+}
+
+func (f *fakeBackend) PartialCloneFilter(ctx context.Context, path string) string {
+	return ""
+}
+
+func (f *fakeBackend) LocalConfig(ctx context.Context, path string, filter gate.ConfigFilter) map[string]string {
+	return nil
+}
+
+func (f *fakeBackend) SparseCheckout(ctx context.Context, path string) *gate.SparseCheckout {
+	return nil
+}
+
+func (f *fakeBackend) SetSparseCheckout(ctx context.Context, path string, sparse *gate.SparseCheckout) error {
+	f.calls = append(f.calls, "sparse-checkout "+path)
+	return nil
+}
+
+func (f *fakeBackend) UsesLFS(ctx context.Context, path string) bool {
+	return false
+}
+
+func (f *fakeBackend) HasLFS() bool {
+	return f.lfs
+}
+
+func (f *fakeBackend) PullLFS(ctx context.Context, path string) error {
+	f.calls = append(f.calls, "lfs pull "+path)
+	return nil
+}
+
+func (f *fakeBackend) Clone(ctx context.Context, url, path string, opts gate.CloneOptions) error {
+	f.calls = append(f.calls, "clone "+url+" "+path)
+	args := []string{"clone", url, path}
+	remote, ok := f.remotes[url]
+	if !ok {
+		return f.errorf("", args, "fatal: repository '%s' does not exist", url)
+	}
+	if remote.cloneErr != nil {
+		return remote.cloneErr
+	}
+	if len(remote.cloneErrs) > 0 {
+		err := remote.cloneErrs[0]
+		remote.cloneErrs = remote.cloneErrs[1:]
+		return err
+	}
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return err
+	}
+	if remote.interrupt {
+		syscall.Kill(os.Getpid(), syscall.SIGINT)
+		<-ctx.Done()
+		return &gate.GitError{Args: args, ExitCode: -1, Err: ctx.Err()}
+	}
+	if opts.Progress != nil {
+		for _, p := range remote.progress {
+			opts.Progress("Receiving objects", p)
+		}
+	}
+	repo := &fakeRepo{remoteURL: url, commits: map[string]bool{}, bare: opts.Bare || opts.Mirror}
+	for _, c := range remote.commits {
+		repo.commits[c] = true
+	}
+	f.addRepo(path, repo)
+	return nil
+}
+
+func (f *fakeBackend) HasCommit(ctx context.Context, path, commit string) bool {
+	return f.repo(path).commits[commit]
+}
+
+func (f *fakeBackend) Fetch(ctx context.Context, path, refspec string) error {
+	f.calls = append(f.calls, "fetch "+refspec)
+	r := f.repo(path)
+	commit, ok := f.remotes[r.remoteURL].fetches[refspec]
+	if !ok {
+		return f.errorf(path, []string{"fetch", "--", "origin", refspec}, "fatal: couldn't find remote ref %s", refspec)
+	}
+	r.commits[commit] = true
+	return nil
+}
+
+func (f *fakeBackend) SetHead(ctx context.Context, path, branch string) error {
+	f.calls = append(f.calls, "set HEAD "+branch)
+	f.repo(path).branch = branch
+	return nil
+}
+
+func (f *fakeBackend) SetConfig(ctx context.Context, path string, config map[string]string) error {
+	keys := make([]string, 0, len(config))
+	for k := range config {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		f.calls = append(f.calls, "config "+k+"="+config[k])
+	}
+	return nil
+}
+
+func (f *fakeBackend) Checkout(ctx context.Context, path, branch, commit string) error {
+	f.calls = append(f.calls, "checkout "+branch+" "+commit)
+	r := f.repo(path)
+	if !r.commits[commit] {
+		return f.errorf(path, []string{"reset", "--hard", commit}, "fatal: Could not parse object '%s'.", commit)
+	}
+	r.branch, r.commit = branch, commit
+	return nil
+}
+
+func (f *fakeBackend) CheckoutDetached(ctx context.Context, path, commit string) error {
+	return f.Checkout(ctx, path, "HEAD", commit)
+}
+
+func (f *fakeBackend) AddWorktree(ctx context.Context, mainPath, worktreePath, branch, commit string, detached bool, sparse *gate.SparseCheckout) error {
+	f.calls = append(f.calls, "worktree add "+worktreePath+" "+branch)
+	main := f.repo(mainPath)
+	if !main.commits[commit] {
+		return f.errorf(mainPath, []string{"worktree", "add", worktreePath, branch}, "fatal: invalid reference: %s", commit)
+	}
+	if err := os.MkdirAll(worktreePath, 0755); err != nil {
+		return err
+	}
+	if detached {
+		branch = "HEAD"
+	}
+	f.addRepo(worktreePath, &fakeRepo{branch: branch, commit: commit, commits: main.commits, mainPath: mainPath})
+	return nil
+}
+
+func (f *fakeBackend) RemoveWorktree(ctx context.Context, mainPath, worktreePath string) error {
+	f.calls = append(f.calls, "worktree remove "+worktreePath)
+	delete(f.repos, abs(worktreePath))
+	return os.RemoveAll(worktreePath)
+}
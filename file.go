@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/leighmcculloch/gate/gate"
+)
+
+// stdio is the file name that means stdin or stdout
+const stdio = "-"
+
+// formatFromExt returns the format implied by the extension of path, if any
+func formatFromExt(path string) (gate.Format, bool) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return gate.FormatJSON, true
+	case ".yaml", ".yml":
+		return gate.FormatYAML, true
+	case ".toml":
+		return gate.FormatTOML, true
+	}
+	return "", false
+}
+
+// writeOutput writes data to path, or to stdout when path is "-"
+func writeOutput(path string, data []byte, stdout io.Writer) error {
+	if path == stdio {
+		_, err := stdout.Write(data)
+		return err
+	}
+	if err := writeFileAtomic(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// writeFileAtomic replaces the file at path with data by writing a temporary
+// file beside it and renaming it into place, so that the file is never left
+// truncated or half written. An existing file keeps its permissions, and a
+// new file is created with perm.
+func writeFileAtomic(path string, data []byte, perm fs.FileMode) error {
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	// Remove the temporary file unless it was renamed into place
+	defer os.Remove(tmp)
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// readInput reads path, or stdin when path is "-"
+func readInput(path string, stdin io.Reader) ([]byte, error) {
+	if path == stdio {
+		data, err := io.ReadAll(stdin)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read stdin: %w", err)
+		}
+		return data, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return data, nil
+}
+
+// readStateFiles reads and merges the state in each of paths, where "-"
+// reads stdin. Encrypted files are decrypted with keys. A repository that
+// appears in more than one file must be the same in each.
+func readStateFiles(paths []string, stdin io.Reader, keys gate.DecryptOptions, logger *slog.Logger) (*gate.State, error) {
+	merged := &gate.State{}
+	seen := map[string]int{}
+	from := map[string]string{}
+	readStdin := false
+	for _, path := range paths {
+		name := path
+		if path == stdio {
+			if readStdin {
+				return nil, fmt.Errorf("stdin can only be read once")
+			}
+			readStdin = true
+			name = "stdin"
+		}
+		data, err := readInput(path, stdin)
+		if err != nil {
+			return nil, err
+		}
+		data, _, err = decryptInput(data, name, keys, logger)
+		if err != nil {
+			return nil, err
+		}
+
+		var state gate.State
+		format, err := gate.Unmarshal(data, &state)
+		if err != nil {
+			if len(paths) > 1 {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			return nil, err
+		}
+		logger.Debug(fmt.Sprintf("parsed %s from %s (%d bytes, %d repositories)", strings.ToUpper(string(format)), name, len(data), len(state.Repositories)))
+
+		for _, repo := range state.Repositories {
+			if i, ok := seen[repo.Path]; ok {
+				if !reflect.DeepEqual(merged.Repositories[i], repo) {
+					return nil, fmt.Errorf("%s is in both %s and %s with different state", repo.Path, from[repo.Path], name)
+				}
+				continue
+			}
+			seen[repo.Path] = len(merged.Repositories)
+			from[repo.Path] = name
+			merged.Repositories = append(merged.Repositories, repo)
+		}
+	}
+	return merged, nil
+}
+
+// readRewrites parses the URL rewrite rules given as flags, followed by
+// those in the file at path when it is not empty
+func readRewrites(rules []string, path string) ([]gate.URLRewrite, error) {
+	var rewrites []gate.URLRewrite
+	for _, rule := range rules {
+		rw, err := gate.ParseURLRewrite(rule)
+		if err != nil {
+			return nil, err
+		}
+		rewrites = append(rewrites, rw)
+	}
+	if path == "" {
+		return rewrites, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	fromFile, err := gate.ParseURLRewrites(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return append(rewrites, fromFile...), nil
+}
@@ -0,0 +1,508 @@
+package gate
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// apply sets up the repositories in state and reports the outcome for each.
+// When ctx is cancelled no further repositories are started, and the error
+// describes the interruption.
+func apply(ctx context.Context, state *State, opts Options) (*Report, error) {
+	opts.Logger.Debug("sorting repositories (main checkouts before worktrees)")
+	repos := applyOrder(state.Repositories)
+
+	if opts.Journal != "" {
+		// The journal belongs to the directory being applied to, not
+		// wherever the process happens to run
+		journalPath := opts.Journal
+		if !filepath.IsAbs(journalPath) {
+			journalPath = filepath.Join(opts.Dir, journalPath)
+		}
+		j, err := openJournal(journalPath, opts.Resume)
+		if err != nil {
+			return nil, err
+		}
+		defer j.Close()
+		opts.journal = j
+	} else if opts.Resume {
+		return nil, fmt.Errorf("resume requires a journal")
+	}
+
+	placed, layoutErrs := layoutRepositories(repos, opts)
+
+	report := &Report{Results: make([]Result, 0, len(repos))}
+	// unfinished counts the repositories left for a later resume
+	unfinished := 0
+	for i, repo := range placed {
+		if ctx.Err() != nil {
+			break
+		}
+		opts.Logger.Debug(fmt.Sprintf("processing repository %d/%d: %s", i+1, len(repos), repo.Path), "path", repo.Path)
+		opts.progress(Event{Kind: EventRepoStart, Path: repo.Path, Count: i + 1, Total: len(repos)})
+		result := Result{Path: repo.Path}
+		if repo.Path != repos[i].Path {
+			result.StatePath = repos[i].Path
+			opts.Logger.Info(fmt.Sprintf("placing %s at %s", repos[i].Path, repo.Path), "event", "relocated", "path", repo.Path, "state_path", repos[i].Path)
+		}
+		status, err := StatusFailed, layoutErrs[i]
+		if err == nil {
+			status, err = applyRepo(ctx, repo, opts)
+		}
+		if err != nil {
+			opts.Logger.Error(fmt.Sprintf("%s: %v", repo.Path, err), "event", "failed", "path", repo.Path, "error", err)
+			// Continue with other repos
+		}
+		opts.progress(Event{Kind: EventRepoDone, Path: repo.Path, Count: i + 1, Total: len(repos), Status: status, Err: err})
+		result.Status, result.Err = status, err
+		if status == StatusFailed || (status == StatusSkipped && opts.Resume && opts.journal.incomplete(repo.Path)) {
+			unfinished++
+		}
+		report.Results = append(report.Results, result)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return report, fmt.Errorf("apply interrupted: %w", err)
+	}
+
+	// There is nothing left to resume once every repository is set up
+	if unfinished == 0 && opts.journal != nil {
+		if err := opts.journal.remove(); err != nil {
+			opts.Logger.Warn(fmt.Sprintf("failed to remove journal: %v", err), "error", err)
+		} else {
+			opts.Logger.Debug(fmt.Sprintf("removed journal %s", opts.journal.path))
+		}
+	}
+
+	opts.Logger.Debug("apply complete", "event", "apply_complete")
+
+	return report, nil
+}
+
+// applyOrder returns a sorted copy of repos in the order they are set up,
+// with main checkouts before the worktrees that are added to them
+func applyOrder(repos []Repository) []Repository {
+	sorted := make([]Repository, len(repos))
+	copy(sorted, repos)
+	sort.Slice(sorted, func(i, j int) bool {
+		// Main checkouts come first
+		if sorted[i].IsWorktree != sorted[j].IsWorktree {
+			return !sorted[i].IsWorktree
+		}
+		return sorted[i].Path < sorted[j].Path
+	})
+	return sorted
+}
+
+// applyRepo sets up a single repository
+func applyRepo(ctx context.Context, repo Repository, opts Options) (Status, error) {
+	path := filepath.Join(opts.Dir, repo.Path)
+	// Everything logged while setting up the repository is attributed to it
+	opts.Logger = opts.Logger.With("path", repo.Path)
+
+	if opts.Resume && opts.journal.completed(repo.Path) {
+		opts.Logger.Info(fmt.Sprintf("skipping %s, already completed", repo.Path), "event", "skipped", "reason", "completed")
+		return StatusSkipped, nil
+	}
+
+	// Check if path already exists
+	created := false
+	if _, err := os.Stat(path); err == nil {
+		if !opts.Resume || !opts.journal.incomplete(repo.Path) {
+			opts.Logger.Warn(fmt.Sprintf("%s already exists, skipping", repo.Path), "event", "skipped", "reason", "exists")
+			return StatusSkipped, nil
+		}
+		var status Status
+		status, created = resumeExisting(ctx, path, repo, opts)
+		if status != "" {
+			return status, nil
+		}
+	}
+
+	err := opts.journal.step(repo.Path, journalRepository, func() error {
+		if repo.IsWorktree {
+			return applyWorktree(ctx, path, repo, created, opts)
+		}
+		return applyMainCheckout(ctx, path, repo, created, opts)
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			removePartial(ctx, path, repo, opts)
+		}
+		return StatusFailed, err
+	}
+	return StatusCreated, nil
+}
+
+// resumeExisting decides what to do with the repository at path, which a
+// previous apply started setting up but did not finish. A clone or worktree
+// that was cut short is removed to be created again. One that was created,
+// with a later step failing, is finished in place, and created is true. It
+// is skipped, returning StatusSkipped, when it has changed since, so that
+// nothing made after the failure is lost, or when the previous apply never
+// created it.
+func resumeExisting(ctx context.Context, path string, repo Repository, opts Options) (skip Status, created bool) {
+	createStep := "clone"
+	if repo.IsWorktree {
+		createStep = "worktree"
+	}
+	status, ok := opts.journal.status(repo.Path, createStep)
+	switch {
+	case !ok:
+		opts.Logger.Warn(fmt.Sprintf("%s already exists and was not created by apply, skipping", repo.Path), "event", "skipped", "reason", "exists")
+		return StatusSkipped, false
+	case status != journalDone:
+		opts.Logger.Info(fmt.Sprintf("resuming %s", repo.Path), "event", "resumed")
+		removePartial(ctx, path, repo, opts)
+		return "", false
+	}
+
+	dirty, err := opts.Git.HasUncommittedChanges(ctx, path)
+	if err != nil || dirty {
+		opts.Logger.Warn(fmt.Sprintf("%s has changed since it was created, skipping", repo.Path), "event", "skipped", "reason", "changed")
+		return StatusSkipped, false
+	}
+	opts.Logger.Info(fmt.Sprintf("resuming %s in place", repo.Path), "event", "resumed")
+	return "", true
+}
+
+// removePartial removes a repository or worktree that was partially created
+// when apply was interrupted, so that a rerun sets it up again instead of
+// skipping it as already existing
+func removePartial(ctx context.Context, path string, repo Repository, opts Options) {
+	if _, err := os.Stat(path); err != nil {
+		return
+	}
+
+	// Cleanup must run even though ctx is cancelled
+	ctx = context.WithoutCancel(ctx)
+
+	var err error
+	if repo.IsWorktree && repo.MainCheckoutPath != nil {
+		err = opts.Git.RemoveWorktree(ctx, worktreeMainPath(path, repo), path)
+	} else {
+		err = os.RemoveAll(path)
+	}
+	if err != nil {
+		opts.Logger.Warn(fmt.Sprintf("failed to remove partially created %s: %v", repo.Path, err), "error", err)
+		return
+	}
+	opts.Logger.Info(fmt.Sprintf("  removed partially created %s", repo.Path), "event", "removed_partial")
+}
+
+// worktreeMainPath resolves the main checkout of a worktree at path
+func worktreeMainPath(path string, repo Repository) string {
+	mainPath := *repo.MainCheckoutPath
+	if !filepath.IsAbs(mainPath) {
+		// MainCheckoutPath is relative to the worktree path
+		mainPath = filepath.Join(path, mainPath)
+	}
+	return filepath.Clean(mainPath)
+}
+
+// applyMainCheckout clones and checks out a main repository. When cloned is
+// true the clone already exists at path and only the later steps are run.
+func applyMainCheckout(ctx context.Context, path string, repo Repository, cloned bool, opts Options) error {
+	if repo.RemoteURL == "" {
+		return fmt.Errorf("no remote URL for main checkout")
+	}
+
+	if !cloned {
+		if err := cloneMainCheckout(ctx, path, repo, opts); err != nil {
+			return err
+		}
+	}
+
+	if repo.Bare {
+		if err := applyBareHead(ctx, path, repo, opts); err != nil {
+			return err
+		}
+		return opts.journal.step(repo.Path, "config", func() error {
+			return applyConfig(ctx, path, repo, opts)
+		})
+	}
+
+	if repo.SparseCheckout != nil {
+		opts.Logger.Debug(fmt.Sprintf("  setting sparse-checkout patterns (%d)", len(repo.SparseCheckout.Patterns)))
+		if err := opts.Git.SetSparseCheckout(ctx, path, repo.SparseCheckout); err != nil {
+			return fmt.Errorf("failed to set sparse-checkout: %w", err)
+		}
+	}
+
+	err := opts.journal.step(repo.Path, "fetch", func() error {
+		return ensureCommit(ctx, path, repo, opts)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Checkout the correct branch and commit
+	err = opts.journal.step(repo.Path, "checkout", func() error {
+		if repo.isDetached() {
+			opts.Logger.Debug(fmt.Sprintf("  detaching HEAD at commit %s", repo.Commit))
+			return opts.Git.CheckoutDetached(ctx, path, repo.Commit)
+		}
+		opts.Logger.Debug(fmt.Sprintf("  checking out branch %s", repo.Branch))
+		opts.Logger.Debug(fmt.Sprintf("  resetting to commit %s", repo.Commit))
+		return opts.Git.Checkout(ctx, path, repo.Branch, repo.Commit)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to checkout: %w", err)
+	}
+
+	err = opts.journal.step(repo.Path, "lfs", func() error {
+		return applyLFS(ctx, path, repo, opts)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = opts.journal.step(repo.Path, "config", func() error {
+		return applyConfig(ctx, path, repo, opts)
+	})
+	if err != nil {
+		return err
+	}
+
+	opts.Logger.Info(fmt.Sprintf("  checked out %s", repo.checkoutLabel()), "event", "checked_out", "branch", repo.Branch, "commit", repo.Commit)
+	return nil
+}
+
+// applyConfig sets the captured config that opts.Config allows in a new
+// clone, warning about each key it drops. It runs last, so that config such
+// as core.hooksPath cannot run hooks from the repository while apply checks
+// out files.
+func applyConfig(ctx context.Context, path string, repo Repository, opts Options) error {
+	config, dropped := filterConfig(repo, opts.Config)
+	for _, warning := range dropped {
+		opts.Logger.Warn(warning, "event", "config_dropped")
+	}
+	if len(config) == 0 {
+		return nil
+	}
+	opts.Logger.Debug(fmt.Sprintf("  setting %d config keys", len(config)))
+	if err := opts.Git.SetConfig(ctx, path, config); err != nil {
+		return fmt.Errorf("failed to set config: %w", err)
+	}
+	return nil
+}
+
+// cloneMainCheckout clones a main repository to path with the captured clone
+// options
+func cloneMainCheckout(ctx context.Context, path string, repo Repository, opts Options) error {
+	remoteURL := rewriteURL(repo.RemoteURL, opts.Rewrites)
+	// Rewrites may add credentials, e.g. a token in CI, that only git sees
+	logURL, _ := scrubURL(remoteURL)
+	opts.Logger.Info(fmt.Sprintf("cloning %s from %s", repo.Path, logURL), "event", "clone_started", "url", logURL)
+	if remoteURL != repo.RemoteURL {
+		originalURL, _ := scrubURL(repo.RemoteURL)
+		opts.Logger.Debug(fmt.Sprintf("  remote URL rewritten from %s", originalURL), "original_url", originalURL)
+	}
+
+	// Create parent directory if needed
+	parent := filepath.Dir(path)
+	if parent != "" && parent != "." {
+		opts.Logger.Debug(fmt.Sprintf("  creating parent directory: %s", parent))
+		if err := os.MkdirAll(parent, 0755); err != nil {
+			return fmt.Errorf("failed to create parent directory: %w", err)
+		}
+	}
+
+	// Clone the repository
+	opts.Logger.Debug("  running git clone")
+	cloneOpts := CloneOptions{
+		// Sparse patterns must be in place before files are checked out
+		NoCheckout: repo.SparseCheckout != nil,
+		Depth:      repo.Depth,
+		Filter:     repo.Filter,
+		Bare:       repo.Bare,
+		Mirror:     repo.Mirror,
+	}
+	if opts.Progress != nil {
+		cloneOpts.Progress = func(phase string, percent int) {
+			opts.progress(Event{Kind: EventGitProgress, Path: repo.Path, Phase: phase, Percent: percent})
+		}
+	}
+	if opts.Depth > 0 {
+		cloneOpts.Depth = opts.Depth
+	}
+	if opts.Filter != "" {
+		cloneOpts.Filter = opts.Filter
+	}
+	if cloneOpts.Depth > 0 {
+		opts.Logger.Debug(fmt.Sprintf("  using shallow clone depth %d", cloneOpts.Depth))
+	}
+	if cloneOpts.Filter != "" {
+		opts.Logger.Debug(fmt.Sprintf("  using partial clone filter %s", cloneOpts.Filter))
+	}
+	err := opts.journal.step(repo.Path, "clone", func() error {
+		return withRetry(ctx, "clone", opts, func() error {
+			return opts.Git.Clone(ctx, remoteURL, path, cloneOpts)
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to clone: %w", err)
+	}
+	return nil
+}
+
+// ensureCommit fetches the captured commit when the repository does not
+// contain it, for example because it is only on a non-default branch, a pull
+// request ref, or was force-pushed away. It tries the branch, then the commit
+// by SHA, then any extra refspecs, and reports every step that failed.
+func ensureCommit(ctx context.Context, path string, repo Repository, opts Options) error {
+	if repo.Commit == "" || opts.Git.HasCommit(ctx, path, repo.Commit) {
+		return nil
+	}
+
+	if err := checkCommit(repo.Commit); err != nil {
+		return err
+	}
+
+	type fetchStep struct {
+		desc    string
+		refspec string
+	}
+	var steps []fetchStep
+	if !repo.isDetached() && repo.Branch != "" {
+		steps = append(steps, fetchStep{
+			desc:    "fetch branch " + repo.Branch,
+			refspec: "+refs/heads/" + repo.Branch + ":refs/remotes/origin/" + repo.Branch,
+		})
+	}
+	steps = append(steps, fetchStep{desc: "fetch commit by SHA", refspec: repo.Commit})
+	for _, refspec := range opts.FetchRefspecs {
+		steps = append(steps, fetchStep{desc: "fetch refspec " + refspec, refspec: refspec})
+	}
+
+	var failures []string
+	for _, step := range steps {
+		opts.Logger.Debug(fmt.Sprintf("  commit %s not found, trying to %s", shortCommit(repo.Commit), step.desc))
+		err := withRetry(ctx, step.desc, opts, func() error {
+			return opts.Git.Fetch(ctx, path, step.refspec)
+		})
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", step.desc, err))
+			continue
+		}
+		if opts.Git.HasCommit(ctx, path, repo.Commit) {
+			return nil
+		}
+		failures = append(failures, step.desc+": commit not fetched")
+	}
+
+	return fmt.Errorf("commit %s not found on remote (%s)", shortCommit(repo.Commit), strings.Join(failures, "; "))
+}
+
+// checkCommit checks that a commit from state is a full hash before it is
+// passed to git fetch, where anything else could be parsed as an option
+func checkCommit(commit string) error {
+	if !commitPattern.MatchString(commit) {
+		return fmt.Errorf("invalid commit %q: must be a full SHA-1 or SHA-256 hash", commit)
+	}
+	return nil
+}
+
+// applyBareHead points HEAD of a freshly cloned bare repository at the
+// captured branch. Bare repositories have no checkout to reset, so the
+// captured commit is informational only.
+func applyBareHead(ctx context.Context, path string, repo Repository, opts Options) error {
+	kind := "bare"
+	if repo.Mirror {
+		kind = "mirror"
+	}
+
+	if repo.Branch != "" && !repo.isDetached() {
+		opts.Logger.Debug(fmt.Sprintf("  setting HEAD to %s", repo.Branch))
+		if err := opts.Git.SetHead(ctx, path, repo.Branch); err != nil {
+			return fmt.Errorf("failed to set HEAD: %w", err)
+		}
+	}
+
+	opts.Logger.Info(fmt.Sprintf("  cloned %s repository with HEAD at %s", kind, repo.headLabel()), "event", "cloned", "branch", repo.Branch)
+	return nil
+}
+
+// applyLFS fetches LFS objects for a checkout that uses Git LFS, warning
+// instead of failing when git-lfs is not installed
+func applyLFS(ctx context.Context, path string, repo Repository, opts Options) error {
+	if !repo.LFS {
+		return nil
+	}
+	if !opts.Git.HasLFS() {
+		opts.Logger.Warn(fmt.Sprintf("%s uses Git LFS but git-lfs is not installed, files are left as LFS pointers", repo.Path), "event", "lfs_missing")
+		return nil
+	}
+	opts.Logger.Debug("  fetching LFS objects")
+	err := withRetry(ctx, "fetch LFS objects", opts, func() error {
+		return opts.Git.PullLFS(ctx, path)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to fetch LFS objects: %w", err)
+	}
+	return nil
+}
+
+// applyWorktree adds a worktree to an existing repository. When added is
+// true the worktree already exists at path and only the later steps are run.
+func applyWorktree(ctx context.Context, path string, repo Repository, added bool, opts Options) error {
+	if repo.MainCheckoutPath == nil {
+		return fmt.Errorf("no main checkout path for worktree")
+	}
+
+	// Calculate the path to the main checkout
+	mainPath := worktreeMainPath(path, repo)
+
+	opts.Logger.Debug(fmt.Sprintf("  resolved main checkout path: %s", mainPath))
+
+	// Verify main checkout exists
+	if _, err := os.Stat(mainPath); os.IsNotExist(err) {
+		return fmt.Errorf("main checkout %s does not exist", mainPath)
+	}
+
+	// The worktree's commit is fetched into the main checkout it shares
+	// objects with
+	err := opts.journal.step(repo.Path, "fetch", func() error {
+		return ensureCommit(ctx, mainPath, repo, opts)
+	})
+	if err != nil {
+		return err
+	}
+
+	opts.Logger.Info(fmt.Sprintf("adding worktree %s from %s", repo.Path, mainPath), "event", "worktree_started", "main_checkout_path", mainPath)
+
+	// Calculate absolute worktree path for git command
+	absWorktreePath, err := filepath.Abs(path)
+	if err != nil {
+		absWorktreePath = path
+	}
+
+	opts.Logger.Debug(fmt.Sprintf("  absolute worktree path: %s", absWorktreePath))
+	opts.Logger.Debug(fmt.Sprintf("  running git worktree add for %s", repo.headLabel()))
+
+	// Add the worktree
+	if !added {
+		err = opts.journal.step(repo.Path, "worktree", func() error {
+			return opts.Git.AddWorktree(ctx, mainPath, absWorktreePath, repo.Branch, repo.Commit, repo.isDetached(), repo.SparseCheckout)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to add worktree: %w", err)
+		}
+	}
+
+	opts.Logger.Debug(fmt.Sprintf("  resetting to commit %s", repo.Commit))
+
+	err = opts.journal.step(repo.Path, "lfs", func() error {
+		return applyLFS(ctx, absWorktreePath, repo, opts)
+	})
+	if err != nil {
+		return err
+	}
+
+	opts.Logger.Info(fmt.Sprintf("  checked out %s", repo.checkoutLabel()), "event", "checked_out", "branch", repo.Branch, "commit", repo.Commit)
+	return nil
+}
@@ -0,0 +1,64 @@
+package gate
+
+import "context"
+
+// GitBackend is the set of git operations used by capture and apply. The
+// default implementation, ExecBackend, runs the git command.
+type GitBackend interface {
+	// IsGitRepo checks if a directory is a git repository
+	IsGitRepo(ctx context.Context, path string) bool
+	// IsBareRepo checks if a directory is a bare repository
+	IsBareRepo(ctx context.Context, path string) bool
+	// IsMirror checks if a repository was cloned as a mirror of its origin
+	IsMirror(ctx context.Context, path string) bool
+	// IsWorktree checks if a directory is a worktree, and returns the path
+	// to its main checkout relative to the worktree
+	IsWorktree(ctx context.Context, path string) (bool, string)
+	// Branch returns the current branch name, or "HEAD" if detached
+	Branch(ctx context.Context, path string) (string, error)
+	// Commit returns the current HEAD commit SHA
+	Commit(ctx context.Context, path string) (string, error)
+	// RemoteURL returns the origin remote URL
+	RemoteURL(ctx context.Context, path string) (string, error)
+	// InsteadOf returns the url.<base>.insteadOf rules in git's config
+	InsteadOf(ctx context.Context) ([]URLRewrite, error)
+	// HasUncommittedChanges checks if there are uncommitted changes
+	HasUncommittedChanges(ctx context.Context, path string) (bool, error)
+	// ShallowDepth returns the depth of a shallow repository, or 0
+	ShallowDepth(ctx context.Context, path string) int
+	// PartialCloneFilter returns the partial clone filter of origin, or ""
+	PartialCloneFilter(ctx context.Context, path string) string
+	// LocalConfig returns the repository-local config entries matching filter
+	LocalConfig(ctx context.Context, path string, filter ConfigFilter) map[string]string
+	// SparseCheckout returns the sparse-checkout settings, or nil
+	SparseCheckout(ctx context.Context, path string) *SparseCheckout
+	// SetSparseCheckout enables sparse-checkout with the given settings
+	SetSparseCheckout(ctx context.Context, path string, sparse *SparseCheckout) error
+	// UsesLFS checks if a checkout uses Git LFS
+	UsesLFS(ctx context.Context, path string) bool
+	// HasLFS checks if the git-lfs extension is installed
+	HasLFS() bool
+	// PullLFS installs LFS hooks and fetches LFS objects for the checkout
+	PullLFS(ctx context.Context, path string) error
+	// Clone clones a repository
+	Clone(ctx context.Context, url, path string, opts CloneOptions) error
+	// HasCommit checks if a commit exists in a repository
+	HasCommit(ctx context.Context, path, commit string) bool
+	// Fetch fetches a refspec, branch name, or commit SHA from origin
+	Fetch(ctx context.Context, path, refspec string) error
+	// SetHead points HEAD of a bare repository at a branch
+	SetHead(ctx context.Context, path, branch string) error
+	// SetConfig sets repository-local config entries
+	SetConfig(ctx context.Context, path string, config map[string]string) error
+	// Checkout checks out a branch and resets it to a commit
+	Checkout(ctx context.Context, path, branch, commit string) error
+	// CheckoutDetached detaches HEAD at a commit
+	CheckoutDetached(ctx context.Context, path, commit string) error
+	// AddWorktree adds a worktree to the repository at mainPath
+	AddWorktree(ctx context.Context, mainPath, worktreePath, branch, commit string, detached bool, sparse *SparseCheckout) error
+	// RemoveWorktree removes a worktree and its administrative files from the
+	// repository at mainPath
+	RemoveWorktree(ctx context.Context, mainPath, worktreePath string) error
+}
+
+var _ GitBackend = ExecBackend{}
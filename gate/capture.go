@@ -0,0 +1,240 @@
+package gate
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+)
+
+// capture scans for git repositories and returns the state
+func capture(ctx context.Context, opts Options) (*State, error) {
+	cwd, err := filepath.Abs(opts.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current directory: %w", err)
+	}
+
+	opts.Logger.Debug(fmt.Sprintf("starting capture from %s", cwd), "dir", cwd)
+
+	repos := make(map[string]*Repository)
+
+	// Search upward
+	opts.Logger.Debug("searching parent directories")
+	searchUpward(ctx, cwd, repos, opts)
+
+	// Search current directory and downward
+	opts.Logger.Debug("searching current directory and subdirectories")
+	searchDownward(ctx, cwd, repos, opts)
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// Convert map to sorted slice
+	state := &State{
+		Repositories: make([]Repository, 0, len(repos)),
+	}
+
+	// Get all paths and sort them
+	paths := make([]string, 0, len(repos))
+	for p := range repos {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	for _, p := range paths {
+		state.Repositories = append(state.Repositories, *repos[p])
+	}
+
+	opts.Logger.Debug(fmt.Sprintf("found %d repositories", len(state.Repositories)), "event", "capture_complete", "count", len(state.Repositories))
+
+	return state, nil
+}
+
+// searchUpward walks parent directories looking for git repos
+func searchUpward(ctx context.Context, startPath string, repos map[string]*Repository, opts Options) {
+	current := startPath
+
+	for ctx.Err() == nil {
+		parent := filepath.Dir(current)
+		if parent == current {
+			// Reached root
+			opts.Logger.Debug("  reached filesystem root")
+			break
+		}
+
+		opts.Logger.Debug(fmt.Sprintf("  checking %s", parent), "dir", parent)
+		opts.progress(Event{Kind: EventScan, Path: parent})
+
+		if opts.Git.IsGitRepo(ctx, parent) {
+			relPath, err := filepath.Rel(startPath, parent)
+			if err != nil {
+				relPath = parent
+			}
+			opts.Logger.Debug(fmt.Sprintf("  found repository: %s", relPath), "event", "repo_found", "path", relPath)
+			addRepo(ctx, parent, relPath, repos, opts)
+		}
+
+		current = parent
+	}
+}
+
+// searchDownward walks subdirectories looking for git repos
+func searchDownward(ctx context.Context, startPath string, repos map[string]*Repository, opts Options) {
+	filepath.WalkDir(startPath, func(path string, d fs.DirEntry, err error) error {
+		// Stop walking once cancelled
+		if ctx.Err() != nil {
+			return filepath.SkipAll
+		}
+
+		if err != nil {
+			opts.Logger.Debug(fmt.Sprintf("  skipping %s: %v", path, err), "dir", path, "error", err)
+			return nil // Skip directories we can't read
+		}
+
+		if !d.IsDir() {
+			return nil
+		}
+
+		// Skip .git directories
+		if d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+
+		opts.progress(Event{Kind: EventScan, Path: path})
+
+		if opts.Git.IsGitRepo(ctx, path) {
+			relPath, err := filepath.Rel(startPath, path)
+			if err != nil {
+				relPath = path
+			}
+			if relPath == "" {
+				relPath = "."
+			}
+			opts.Logger.Debug(fmt.Sprintf("  found repository: %s", relPath), "event", "repo_found", "path", relPath)
+			addRepo(ctx, path, relPath, repos, opts)
+
+			// Skip subdirectories of this repo
+			return filepath.SkipDir
+		}
+
+		return nil
+	})
+}
+
+// addRepo creates a Repository entry and adds it to the map
+func addRepo(ctx context.Context, absPath, relPath string, repos map[string]*Repository, opts Options) {
+	// Skip if already processed
+	if _, exists := repos[relPath]; exists {
+		opts.Logger.Debug(fmt.Sprintf("    skipping %s (already processed)", relPath), "path", relPath)
+		return
+	}
+
+	opts.Logger.Debug(fmt.Sprintf("    processing %s", relPath), "path", relPath)
+
+	bare := opts.Git.IsBareRepo(ctx, absPath)
+
+	// Check for uncommitted changes and warn
+	if !bare {
+		dirty, err := opts.Git.HasUncommittedChanges(ctx, absPath)
+		if err != nil {
+			opts.Logger.Debug(fmt.Sprintf("    failed to check for uncommitted changes: %v", err), "path", relPath, "error", err)
+		}
+		if dirty {
+			opts.Logger.Warn(fmt.Sprintf("%s has uncommitted changes", relPath), "event", "uncommitted_changes", "path", relPath)
+		}
+	}
+
+	isWt, mainPath := opts.Git.IsWorktree(ctx, absPath)
+
+	if bare {
+		opts.Logger.Debug("    detected as bare repository", "path", relPath)
+	} else if isWt {
+		opts.Logger.Debug(fmt.Sprintf("    detected as worktree (main checkout: %s)", mainPath), "path", relPath, "main_checkout_path", mainPath)
+	} else {
+		opts.Logger.Debug("    detected as main checkout", "path", relPath)
+	}
+
+	branch, err := opts.Git.Branch(ctx, absPath)
+	if err != nil {
+		opts.Logger.Debug(fmt.Sprintf("    failed to get branch: %v", err), "path", relPath, "error", err)
+	}
+	commit, err := opts.Git.Commit(ctx, absPath)
+	if err != nil {
+		opts.Logger.Debug(fmt.Sprintf("    failed to get commit: %v", err), "path", relPath, "error", err)
+	}
+
+	opts.Logger.Debug(fmt.Sprintf("    branch: %s, commit: %s", branch, shortCommit(commit)), "path", relPath, "branch", branch, "commit", commit)
+
+	repo := &Repository{
+		Path:       relPath,
+		Branch:     branch,
+		Commit:     commit,
+		Detached:   branch == "HEAD",
+		IsWorktree: isWt,
+		Bare:       bare,
+	}
+
+	// Sparse-checkout is per worktree, so it is recorded for every checkout
+	repo.SparseCheckout = opts.Git.SparseCheckout(ctx, absPath)
+	if repo.SparseCheckout != nil {
+		opts.Logger.Debug(fmt.Sprintf("    sparse-checkout: cone=%t, %d patterns", repo.SparseCheckout.Cone, len(repo.SparseCheckout.Patterns)), "path", relPath)
+	}
+
+	// LFS attributes can differ between the commits checked out in each
+	// worktree, so it is recorded for every checkout
+	repo.LFS = opts.Git.UsesLFS(ctx, absPath)
+	if repo.LFS {
+		opts.Logger.Debug("    uses Git LFS", "path", relPath)
+	}
+
+	if isWt {
+		repo.MainCheckoutPath = &mainPath
+	} else {
+		// Only get remote URL for main checkouts
+		remoteURL, err := opts.Git.RemoteURL(ctx, absPath)
+		if err != nil {
+			opts.Logger.Debug(fmt.Sprintf("    no remote URL: %v", err), "path", relPath, "error", err)
+		}
+		repo.RemoteURL = rewriteURL(remoteURL, opts.Rewrites)
+		if !opts.KeepCredentials {
+			for _, w := range scrubRemoteURL(repo) {
+				opts.Logger.Warn(w, "event", "credentials_removed", "path", relPath)
+			}
+			// Credentials are not logged either
+			remoteURL, _ = scrubURL(remoteURL)
+		}
+		repo.Mirror = bare && opts.Git.IsMirror(ctx, absPath)
+		if repo.RemoteURL != "" {
+			opts.Logger.Debug(fmt.Sprintf("    remote: %s", repo.RemoteURL), "path", relPath, "url", repo.RemoteURL)
+		}
+		if repo.RemoteURL != remoteURL {
+			opts.Logger.Debug(fmt.Sprintf("    rewritten from %s", remoteURL), "path", relPath, "original_url", remoteURL)
+		}
+
+		repo.Depth = opts.Git.ShallowDepth(ctx, absPath)
+		repo.Filter = opts.Git.PartialCloneFilter(ctx, absPath)
+		if repo.Depth > 0 {
+			opts.Logger.Debug(fmt.Sprintf("    shallow: depth %d", repo.Depth), "path", relPath)
+		}
+		if repo.Filter != "" {
+			opts.Logger.Debug(fmt.Sprintf("    partial clone filter: %s", repo.Filter), "path", relPath)
+		}
+
+		// Config is shared between a main checkout and its worktrees, so
+		// it is only recorded once on the main checkout
+		repo.Config = opts.Git.LocalConfig(ctx, absPath, opts.Config)
+		if !opts.KeepCredentials {
+			for _, w := range scrubConfig(repo) {
+				opts.Logger.Warn(w, "event", "credentials_removed", "path", relPath)
+			}
+		}
+		if len(repo.Config) > 0 {
+			opts.Logger.Debug(fmt.Sprintf("    config: %d keys", len(repo.Config)), "path", relPath)
+		}
+	}
+
+	repos[relPath] = repo
+	opts.progress(Event{Kind: EventFound, Path: relPath, Count: len(repos)})
+}
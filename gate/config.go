@@ -0,0 +1,132 @@
+package gate
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DefaultConfigInclude lists the repository-local git config keys captured by
+// default. Patterns are matched case-insensitively and may contain * wildcards.
+var DefaultConfigInclude = []string{
+	"user.name",
+	"user.email",
+	"user.signingkey",
+	"core.hookspath",
+	"core.autocrlf",
+	"core.eol",
+	"commit.gpgsign",
+	"tag.gpgsign",
+	"gpg.format",
+	"pull.rebase",
+	"pull.ff",
+	"push.default",
+	"fetch.prune",
+	"rebase.autostash",
+}
+
+// DefaultConfigExclude lists config keys that are never captured by default
+// because they commonly hold credentials
+var DefaultConfigExclude = []string{
+	"http.extraheader",
+	"http.*.extraheader",
+	"credential.*",
+	"*.token",
+	"*.password",
+}
+
+// ConfigFilter selects which repository-local config keys are captured and
+// applied
+type ConfigFilter struct {
+	// Include lists the keys to capture and apply. Defaults to
+	// DefaultConfigInclude when nil.
+	Include []string
+	// Exclude lists the keys never to capture or apply, even when included.
+	// Defaults to DefaultConfigExclude when nil, so that keys holding
+	// credentials stay excluded when only Include is set. An empty, non-nil
+	// slice excludes nothing.
+	Exclude []string
+}
+
+// orDefault returns f with DefaultConfigInclude and DefaultConfigExclude
+// filled in for nil lists
+func (f ConfigFilter) orDefault() ConfigFilter {
+	if f.Include == nil {
+		f.Include = DefaultConfigInclude
+	}
+	if f.Exclude == nil {
+		f.Exclude = DefaultConfigExclude
+	}
+	return f
+}
+
+// filterConfig returns the config of repo that filter allows, and a warning
+// for each key it drops. State can be edited by hand or come from another
+// machine, and keys such as core.fsmonitor and core.sshCommand run commands,
+// so config is checked again before it is applied.
+func filterConfig(repo Repository, filter ConfigFilter) (map[string]string, []string) {
+	keys := make([]string, 0, len(repo.Config))
+	for k := range repo.Config {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var config map[string]string
+	var warnings []string
+	for _, k := range keys {
+		if !filter.matches(k) {
+			warnings = append(warnings, fmt.Sprintf("%s: config %s is not allowed, not applied", repo.Path, k))
+			continue
+		}
+		if config == nil {
+			config = map[string]string{}
+		}
+		config[k] = repo.Config[k]
+	}
+	return config, warnings
+}
+
+// matches reports whether key is included and not excluded by the filter
+func (f ConfigFilter) matches(key string) bool {
+	key = strings.ToLower(key)
+	included := false
+	for _, p := range f.Include {
+		if matchWildcard(strings.ToLower(p), key) {
+			included = true
+			break
+		}
+	}
+	if !included {
+		return false
+	}
+	for _, p := range f.Exclude {
+		if matchWildcard(strings.ToLower(p), key) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchWildcard reports whether s matches pattern, where * matches any
+// sequence of characters (including dots)
+func matchWildcard(pattern, s string) bool {
+	if pattern == "" {
+		return false
+	}
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+	return strings.HasSuffix(s, parts[len(parts)-1])
+}
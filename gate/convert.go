@@ -0,0 +1,37 @@
+package gate
+
+import "fmt"
+
+// ExportOptions controls exporting state to another tool's file format
+type ExportOptions struct {
+	// Exact pins repositories to their commit rather than their branch, for
+	// formats that can only record one of them
+	Exact bool
+}
+
+// lossyFields describes the details of a main checkout that tool's file
+// format cannot represent and that are dropped when exporting it
+func lossyFields(repo Repository, tool string) []string {
+	var warnings []string
+	drop := func(what string) {
+		warnings = append(warnings, fmt.Sprintf("%s: %s is not supported by %s, dropped", repo.Path, what, tool))
+	}
+	if repo.Mirror {
+		drop("mirror clone")
+	} else if repo.Bare {
+		drop("bare clone")
+	}
+	if repo.Depth > 0 {
+		drop("shallow clone depth")
+	}
+	if repo.Filter != "" {
+		drop("partial clone filter")
+	}
+	if len(repo.Config) > 0 {
+		drop("repository config")
+	}
+	if repo.SparseCheckout != nil {
+		drop("sparse-checkout")
+	}
+	return warnings
+}
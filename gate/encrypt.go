@@ -0,0 +1,423 @@
+package gate
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Encrypted files start with encryptedHeader, followed by a stanza line for
+// each recipient or passphrase that the random file key is wrapped for, a
+// MAC of the header, and the base64 encoded payload encrypted with the file
+// key. The design follows age, but uses AES-256-GCM and PBKDF2 from the Go
+// standard library in place of ChaCha20-Poly1305 and scrypt, so the files
+// are not age files.
+const encryptedHeader = "gate-encrypted/v1"
+
+const (
+	// passphraseIterations is the PBKDF2-HMAC-SHA256 iteration count used
+	// when encrypting with a passphrase
+	passphraseIterations = 600_000
+	// maxPassphraseIterations limits the work a file can ask for to decrypt
+	maxPassphraseIterations = 10_000_000
+
+	recipientPrefix = "gate1"
+	identityPrefix  = "GATE-SECRET-KEY-1"
+)
+
+// b32 encodes keys, and b64 encodes everything else in encrypted files
+var (
+	b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+	b64 = base64.RawStdEncoding
+)
+
+// Recipient is an X25519 public key that files can be encrypted to
+type Recipient struct {
+	key *ecdh.PublicKey
+}
+
+// Identity is an X25519 private key that decrypts files encrypted to its
+// recipient
+type Identity struct {
+	key *ecdh.PrivateKey
+}
+
+// GenerateIdentity creates a new random identity
+func GenerateIdentity() (*Identity, error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{key: key}, nil
+}
+
+// Recipient returns the public key that files are encrypted to for id
+func (id *Identity) Recipient() *Recipient {
+	return &Recipient{key: id.key.PublicKey()}
+}
+
+// String encodes id as GATE-SECRET-KEY-1 followed by the key
+func (id *Identity) String() string {
+	return identityPrefix + strings.ToUpper(encodeKey(id.key.Bytes()))
+}
+
+// String encodes r as gate1 followed by the key
+func (r *Recipient) String() string {
+	return recipientPrefix + strings.ToLower(encodeKey(r.key.Bytes()))
+}
+
+// encodeKey encodes a key with a checksum, so that mistyped keys are
+// rejected rather than encrypting to a key nobody has
+func encodeKey(key []byte) string {
+	sum := sha256.Sum256(key)
+	return b32.EncodeToString(append(key[:len(key):len(key)], sum[:4]...))
+}
+
+// decodeKey decodes a key encoded by encodeKey
+func decodeKey(s string) ([]byte, error) {
+	data, err := b32.DecodeString(strings.ToUpper(s))
+	if err != nil || len(data) != 36 {
+		return nil, fmt.Errorf("malformed key")
+	}
+	key, check := data[:32], data[32:]
+	sum := sha256.Sum256(key)
+	if !bytes.Equal(check, sum[:4]) {
+		return nil, fmt.Errorf("checksum does not match, the key may be mistyped")
+	}
+	return key, nil
+}
+
+// ParseRecipient parses a recipient written by Recipient.String
+func ParseRecipient(s string) (*Recipient, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, recipientPrefix) {
+		return nil, fmt.Errorf("invalid recipient %q: must start with %s", s, recipientPrefix)
+	}
+	key, err := decodeKey(s[len(recipientPrefix):])
+	if err == nil {
+		var pub *ecdh.PublicKey
+		if pub, err = ecdh.X25519().NewPublicKey(key); err == nil {
+			return &Recipient{key: pub}, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid recipient %q: %w", s, err)
+}
+
+// ParseRecipients parses a list of recipients, one per line. Blank lines and
+// lines starting with # are ignored.
+func ParseRecipients(data []byte) ([]*Recipient, error) {
+	var recipients []*Recipient
+	err := eachKeyLine(data, func(line string) error {
+		r, err := ParseRecipient(line)
+		if err != nil {
+			return err
+		}
+		recipients = append(recipients, r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return recipients, nil
+}
+
+// ParseIdentities parses an identity file written by gate keygen, with one
+// identity per line. Blank lines and lines starting with # are ignored.
+func ParseIdentities(data []byte) ([]*Identity, error) {
+	var identities []*Identity
+	err := eachKeyLine(data, func(line string) error {
+		if !strings.HasPrefix(line, identityPrefix) {
+			return fmt.Errorf("invalid identity: must start with %s", identityPrefix)
+		}
+		key, err := decodeKey(line[len(identityPrefix):])
+		if err != nil {
+			return fmt.Errorf("invalid identity: %w", err)
+		}
+		priv, err := ecdh.X25519().NewPrivateKey(key)
+		if err != nil {
+			return fmt.Errorf("invalid identity: %w", err)
+		}
+		identities = append(identities, &Identity{key: priv})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(identities) == 0 {
+		return nil, fmt.Errorf("no identities found")
+	}
+	return identities, nil
+}
+
+// eachKeyLine calls fn with each line of data that is not blank or a comment
+func eachKeyLine(data []byte, fn func(line string) error) error {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := fn(line); err != nil {
+			return fmt.Errorf("line %d: %w", n, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// EncryptOptions selects who can decrypt an encrypted file. Anyone with the
+// identity of any recipient, or the passphrase, can decrypt it.
+type EncryptOptions struct {
+	Recipients []*Recipient
+	// Passphrase also encrypts the file with a passphrase when not empty
+	Passphrase string
+}
+
+// DecryptOptions holds the keys to try when decrypting a file
+type DecryptOptions struct {
+	Identities []*Identity
+	Passphrase string
+}
+
+// IsEncrypted checks if data was encrypted by Encrypt
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(encryptedHeader+"\n"))
+}
+
+// Encrypt encrypts data, such as marshaled state, so that it can only be
+// decrypted with the identity of one of opts.Recipients or opts.Passphrase.
+// The result is text that can be committed or synced like the state itself.
+func Encrypt(data []byte, opts EncryptOptions) ([]byte, error) {
+	if len(opts.Recipients) == 0 && opts.Passphrase == "" {
+		return nil, fmt.Errorf("no recipients or passphrase to encrypt to")
+	}
+	fileKey := make([]byte, 32)
+	rand.Read(fileKey)
+
+	var header strings.Builder
+	header.WriteString(encryptedHeader + "\n")
+	for _, r := range opts.Recipients {
+		ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		shared, err := ephemeral.ECDH(r.key)
+		if err != nil {
+			return nil, err
+		}
+		wrapKey, err := x25519WrapKey(shared, ephemeral.PublicKey(), r.key)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&header, "-> x25519 %s %s\n", b64.EncodeToString(ephemeral.PublicKey().Bytes()), b64.EncodeToString(seal(wrapKey, fileKey)))
+	}
+	if opts.Passphrase != "" {
+		salt := make([]byte, 16)
+		rand.Read(salt)
+		wrapKey, err := pbkdf2.Key(sha256.New, opts.Passphrase, salt, passphraseIterations, 32)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&header, "-> pbkdf2 %d %s %s\n", passphraseIterations, b64.EncodeToString(salt), b64.EncodeToString(seal(wrapKey, fileKey)))
+	}
+	header.WriteString("---")
+
+	mac, err := headerMAC(fileKey, header.String())
+	if err != nil {
+		return nil, err
+	}
+	payloadKey, err := hkdf.Key(sha256.New, fileKey, nil, encryptedHeader+" payload", 32)
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "%s %s\n", header.String(), b64.EncodeToString(mac))
+	payload := b64.EncodeToString(seal(payloadKey, data))
+	for len(payload) > 64 {
+		out.WriteString(payload[:64] + "\n")
+		payload = payload[64:]
+	}
+	out.WriteString(payload + "\n")
+	return out.Bytes(), nil
+}
+
+// Decrypt decrypts data encrypted by Encrypt with one of opts.Identities or
+// opts.Passphrase
+func Decrypt(data []byte, opts DecryptOptions) ([]byte, error) {
+	if !IsEncrypted(data) {
+		return nil, fmt.Errorf("not an encrypted file")
+	}
+	if len(opts.Identities) == 0 && opts.Passphrase == "" {
+		return nil, fmt.Errorf("file is encrypted, but no identity or passphrase was given")
+	}
+	invalid := func(reason string) error {
+		return fmt.Errorf("invalid encrypted file: %s", reason)
+	}
+
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	var fileKey []byte
+	i := 1
+	for ; i < len(lines) && strings.HasPrefix(lines[i], "-> "); i++ {
+		if fileKey != nil {
+			continue
+		}
+		key, err := unwrapStanza(strings.Fields(lines[i])[1:], opts)
+		if err != nil {
+			return nil, invalid(err.Error())
+		}
+		fileKey = key
+	}
+	if i == len(lines) || !strings.HasPrefix(lines[i], "--- ") {
+		return nil, invalid("missing header MAC")
+	}
+	if fileKey == nil {
+		return nil, fmt.Errorf("no identity or passphrase given can decrypt the file")
+	}
+
+	header := strings.Join(lines[:i], "\n") + "\n---"
+	mac, err := b64.DecodeString(strings.TrimPrefix(lines[i], "--- "))
+	if err != nil {
+		return nil, invalid("malformed header MAC")
+	}
+	want, err := headerMAC(fileKey, header)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(mac, want) {
+		return nil, invalid("header has been modified")
+	}
+
+	payload, err := b64.DecodeString(strings.Join(lines[i+1:], ""))
+	if err != nil {
+		return nil, invalid("malformed payload")
+	}
+	payloadKey, err := hkdf.Key(sha256.New, fileKey, nil, encryptedHeader+" payload", 32)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := open(payloadKey, payload)
+	if err != nil {
+		return nil, invalid("payload has been modified")
+	}
+	return plaintext, nil
+}
+
+// unwrapStanza returns the file key wrapped in a stanza, or nil if none of
+// the keys in opts unwrap it
+func unwrapStanza(args []string, opts DecryptOptions) ([]byte, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("empty stanza")
+	}
+	switch args[0] {
+	case "x25519":
+		if len(args) != 3 {
+			return nil, fmt.Errorf("malformed x25519 stanza")
+		}
+		epk, err1 := b64.DecodeString(args[1])
+		wrapped, err2 := b64.DecodeString(args[2])
+		if err := errors.Join(err1, err2); err != nil {
+			return nil, fmt.Errorf("malformed x25519 stanza")
+		}
+		ephemeral, err := ecdh.X25519().NewPublicKey(epk)
+		if err != nil {
+			return nil, fmt.Errorf("malformed x25519 stanza: %w", err)
+		}
+		for _, id := range opts.Identities {
+			shared, err := id.key.ECDH(ephemeral)
+			if err != nil {
+				continue
+			}
+			wrapKey, err := x25519WrapKey(shared, ephemeral, id.key.PublicKey())
+			if err != nil {
+				return nil, err
+			}
+			if key, err := open(wrapKey, wrapped); err == nil {
+				return key, nil
+			}
+		}
+		return nil, nil
+	case "pbkdf2":
+		if len(args) != 4 {
+			return nil, fmt.Errorf("malformed pbkdf2 stanza")
+		}
+		iterations, err := strconv.Atoi(args[1])
+		if err != nil || iterations < 1 || iterations > maxPassphraseIterations {
+			return nil, fmt.Errorf("malformed pbkdf2 stanza")
+		}
+		salt, err1 := b64.DecodeString(args[2])
+		wrapped, err2 := b64.DecodeString(args[3])
+		if err := errors.Join(err1, err2); err != nil {
+			return nil, fmt.Errorf("malformed pbkdf2 stanza")
+		}
+		if opts.Passphrase == "" {
+			return nil, nil
+		}
+		wrapKey, err := pbkdf2.Key(sha256.New, opts.Passphrase, salt, iterations, 32)
+		if err != nil {
+			return nil, err
+		}
+		if key, err := open(wrapKey, wrapped); err == nil {
+			return key, nil
+		}
+		return nil, nil
+	}
+	// Stanzas of other kinds may be readable by other keys
+	return nil, nil
+}
+
+// x25519WrapKey derives the key that wraps the file key for a recipient from
+// the shared secret of an X25519 exchange, bound to the ephemeral and
+// recipient public keys that made it
+func x25519WrapKey(shared []byte, ephemeral, recipient *ecdh.PublicKey) ([]byte, error) {
+	salt := append(ephemeral.Bytes(), recipient.Bytes()...)
+	return hkdf.Key(sha256.New, shared, salt, encryptedHeader+" x25519", 32)
+}
+
+// headerMAC authenticates the header with the file key, so that stanzas
+// cannot be changed without knowing it
+func headerMAC(fileKey []byte, header string) ([]byte, error) {
+	key, err := hkdf.Key(sha256.New, fileKey, nil, encryptedHeader+" header", 32)
+	if err != nil {
+		return nil, err
+	}
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(header))
+	return h.Sum(nil), nil
+}
+
+// seal encrypts plaintext with AES-256-GCM. Every key is used to encrypt
+// only once, so the nonce is always zero.
+func seal(key, plaintext []byte) []byte {
+	return newGCM(key).Seal(nil, make([]byte, 12), plaintext, nil)
+}
+
+// open decrypts ciphertext sealed by seal
+func open(key, ciphertext []byte) ([]byte, error) {
+	return newGCM(key).Open(nil, make([]byte, 12), ciphertext, nil)
+}
+
+// newGCM returns AES-256-GCM with a 32 byte key
+func newGCM(key []byte) cipher.AEAD {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		panic(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+	return gcm
+}
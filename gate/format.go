@@ -0,0 +1,115 @@
+package gate
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Format is a file format that State can be written in
+type Format string
+
+const (
+	// FormatJSON is the default format
+	FormatJSON Format = "json"
+	// FormatYAML is easier to edit by hand and allows comments
+	FormatYAML Format = "yaml"
+	// FormatTOML is easier to edit by hand and allows comments
+	FormatTOML Format = "toml"
+)
+
+// ParseFormat parses the name of a format
+func ParseFormat(name string) (Format, error) {
+	switch f := Format(strings.ToLower(name)); f {
+	case FormatJSON, FormatYAML, FormatTOML:
+		return f, nil
+	case "yml":
+		return FormatYAML, nil
+	}
+	return "", fmt.Errorf("unknown format %q: must be json, yaml or toml", name)
+}
+
+// Marshal encodes state in format. Fields are written in the order they are
+// declared and config keys are sorted, so unchanged state encodes the same
+// way every time.
+func Marshal(state *State, format Format) ([]byte, error) {
+	var buf bytes.Buffer
+	switch format {
+	case FormatJSON:
+		enc := json.NewEncoder(&buf)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(state); err != nil {
+			return nil, err
+		}
+	case FormatYAML:
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err := enc.Encode(state); err != nil {
+			return nil, err
+		}
+		if err := enc.Close(); err != nil {
+			return nil, err
+		}
+	case FormatTOML:
+		enc := toml.NewEncoder(&buf)
+		enc.Indent = ""
+		if err := enc.Encode(state); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal decodes state written in any of the supported formats, detecting
+// which from its content, and returns the format it was written in
+func Unmarshal(data []byte, state *State) (Format, error) {
+	format := DetectFormat(data)
+	var err error
+	switch format {
+	case FormatJSON:
+		err = json.Unmarshal(data, state)
+	case FormatYAML:
+		err = yaml.Unmarshal(data, state)
+	case FormatTOML:
+		_, err = toml.Decode(string(data), state)
+	}
+	if err != nil {
+		return format, fmt.Errorf("failed to parse %s: %w", strings.ToUpper(string(format)), err)
+	}
+	return format, nil
+}
+
+// tomlKeyPattern matches a TOML key/value line, which YAML would write as
+// "key: value"
+var tomlKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_."'-]+\s*=`)
+
+// DetectFormat guesses the format of data from its first line that is not
+// blank or a comment. JSON starts with a brace, TOML with a table header or
+// key = value, and anything else is treated as YAML.
+func DetectFormat(data []byte) Format {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(line, "{"):
+			return FormatJSON
+		case strings.HasPrefix(line, "["), tomlKeyPattern.MatchString(line):
+			return FormatTOML
+		default:
+			return FormatYAML
+		}
+	}
+	// Empty input is reported as invalid JSON, the default format
+	return FormatJSON
+}
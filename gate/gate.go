@@ -0,0 +1,164 @@
+// Package gate captures the state of git repositories and worktrees in a
+// directory tree, and restores that state on another system.
+package gate
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Options controls capture and apply. The zero value captures from and
+// applies into the current directory using the git command and the default
+// config allowlist, without logging.
+type Options struct {
+	// Dir is the directory to capture from or apply into. Defaults to the
+	// current directory.
+	Dir string
+	// Git performs all git operations. Defaults to ExecBackend.
+	Git GitBackend
+	// Logger receives progress, warning and error messages, with detailed
+	// progress at debug level. Messages are written to be read by people, and
+	// are indented to show the steps of setting up each repository. Key
+	// records also carry an "event" attribute, e.g. repo_found,
+	// clone_started, skipped or failed, and attributes such as "path" for
+	// machines to read. Defaults to discarding everything.
+	Logger *slog.Logger
+	// Progress receives events describing how far Capture or Apply has got,
+	// for displaying progress. It may be called from a goroutine other than
+	// the one that called Capture or Apply, but is never called concurrently.
+	Progress func(Event)
+
+	// Config selects the repository-local config keys recorded by Capture
+	// and set by Apply. Its nil lists default to DefaultConfigInclude and
+	// DefaultConfigExclude.
+	Config ConfigFilter
+	// KeepCredentials records credentials in remote URLs and config values
+	// as they are. By default Capture removes them and logs a warning for
+	// each, as Scrub does.
+	KeepCredentials bool
+	// Rewrites are applied to remote URLs by Capture before recording them,
+	// and by Apply before cloning. The rule with the longest matching prefix
+	// is used.
+	Rewrites []URLRewrite
+	// GitInsteadOf adds the url.<base>.insteadOf rules in git's config to
+	// Rewrites, so that URLs are recorded and logged as git would use them.
+	GitInsteadOf bool
+
+	// Depth overrides the captured shallow clone depth in Apply when greater
+	// than zero.
+	Depth int
+	// Filter overrides the captured partial clone filter in Apply when not
+	// empty.
+	Filter string
+	// FetchRefspecs are fetched from origin by Apply as a last resort when
+	// the captured commit is not in the clone, e.g.
+	// "+refs/pull/*/head:refs/remotes/origin/pr/*".
+	FetchRefspecs []string
+	// Retries is how many times Apply retries a clone or fetch that fails
+	// with a transient network error, such as a connection reset, timeout or
+	// HTTP 5xx. Failures such as authentication errors are never retried.
+	Retries int
+	// RetryDelay is the delay before the first retry, which doubles for each
+	// retry after it.
+	RetryDelay time.Duration
+	// Journal is the path of a file, relative to Dir, where Apply records
+	// the steps it takes for each repository. Apply removes it when no
+	// repository is left unfinished. Empty disables the journal.
+	Journal string
+	// Resume continues an earlier Apply recorded in Journal: repositories it
+	// completed are skipped, clones and worktrees it left half created are
+	// removed and set up again, and ones it created before a later step
+	// failed are finished in place unless they have changed since.
+	Resume bool
+	// Layout chooses where Apply places repositories. Defaults to
+	// LayoutCaptured.
+	Layout Layout
+	// LayoutRoot is the directory, relative to Dir, that LayoutGhq places
+	// repositories under. Defaults to Dir.
+	LayoutRoot string
+
+	journal *journal
+}
+
+// withDefaults returns opts with defaults filled in for unset fields
+func (opts Options) withDefaults() Options {
+	if opts.Git == nil {
+		opts.Git = ExecBackend{}
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.New(slog.DiscardHandler)
+	}
+	opts.Config = opts.Config.orDefault()
+	return opts
+}
+
+// Status is the outcome of applying a single repository
+type Status string
+
+const (
+	// StatusCreated means the repository or worktree was created
+	StatusCreated Status = "created"
+	// StatusSkipped means the path already existed and was left alone
+	StatusSkipped Status = "skipped"
+	// StatusFailed means the repository could not be set up
+	StatusFailed Status = "failed"
+)
+
+// Result is the outcome of applying a single repository
+type Result struct {
+	// Path is where the repository was applied
+	Path string
+	// StatePath is the path of the repository in state when Layout placed
+	// it somewhere else, or empty
+	StatePath string
+	Status    Status
+	// Err is the reason the repository failed, or nil
+	Err error
+}
+
+// Report describes what Apply did for each repository, in the order they
+// were applied
+type Report struct {
+	Results []Result
+}
+
+// Failed returns the results of repositories that could not be set up
+func (r *Report) Failed() []Result {
+	var failed []Result
+	for _, res := range r.Results {
+		if res.Status == StatusFailed {
+			failed = append(failed, res)
+		}
+	}
+	return failed
+}
+
+// Capture scans the directories above, below and at opts.Dir for git
+// repositories and returns their state
+func Capture(ctx context.Context, opts Options) (*State, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	opts, err := opts.withDefaults().withInsteadOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return capture(ctx, opts)
+}
+
+// Apply clones repositories and adds worktrees under opts.Dir to match state.
+// A repository that fails does not stop the others; failures are recorded in
+// the returned report. If ctx is cancelled, Apply starts no further
+// repositories, removes any that were partially created, and returns the
+// report so far with an error.
+func Apply(ctx context.Context, state *State, opts Options) (*Report, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	opts, err := opts.withDefaults().withInsteadOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return apply(ctx, state, opts)
+}
@@ -0,0 +1,591 @@
+package gate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GitError is returned when a git command fails, and describes the command,
+// where it ran, and what git reported on stderr
+type GitError struct {
+	Args     []string
+	Dir      string
+	ExitCode int
+	Stderr   string
+	Err      error
+}
+
+func (e *GitError) Error() string {
+	msg := "git " + strings.Join(e.Args, " ")
+	if e.Dir != "" {
+		msg += " (in " + e.Dir + ")"
+	}
+	if e.ExitCode >= 0 {
+		msg += fmt.Sprintf(": exit status %d", e.ExitCode)
+	} else {
+		msg += ": " + e.Err.Error()
+	}
+	if e.Stderr != "" {
+		msg += ": " + e.Stderr
+	}
+	return msg
+}
+
+func (e *GitError) Unwrap() error {
+	return e.Err
+}
+
+// gitWaitDelay is how long git is given to exit after being interrupted
+// before it is killed
+const gitWaitDelay = 10 * time.Second
+
+// git runs a git command in the specified directory and returns stdout. An
+// empty dir runs the command in the current directory. Failures are returned
+// as a *GitError that includes git's stderr.
+func git(ctx context.Context, dir string, args ...string) (string, error) {
+	return gitWithProgress(ctx, dir, nil, args...)
+}
+
+// gitWithProgress runs a git command like git, passing the progress git
+// reports on stderr to progress when it is not nil. The command must be given
+// --progress for git to report progress when stderr is not a terminal.
+func gitWithProgress(ctx context.Context, dir string, progress func(phase string, percent int), args ...string) (string, error) {
+	cmdArgs := args
+	if dir != "" {
+		cmdArgs = append([]string{"-C", dir}, args...)
+	}
+	cmd := exec.CommandContext(ctx, "git", cmdArgs...)
+	// Interrupt rather than kill git when ctx is cancelled so that it can
+	// clean up, e.g. remove a partially cloned directory
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(os.Interrupt)
+	}
+	cmd.WaitDelay = gitWaitDelay
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	var pw *progressWriter
+	if progress != nil {
+		pw = &progressWriter{buf: &stderr, fn: progress}
+		cmd.Stderr = pw
+	}
+	out, err := cmd.Output()
+	if pw != nil {
+		pw.flush()
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		gitErr := &GitError{
+			Args:     args,
+			Dir:      dir,
+			ExitCode: -1,
+			Stderr:   strings.TrimSpace(stderr.String()),
+			Err:      err,
+		}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			gitErr.ExitCode = exitErr.ExitCode()
+		}
+		return "", gitErr
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// ExecBackend is the default GitBackend, which runs the git command
+type ExecBackend struct{}
+
+// IsGitRepo checks if a directory is a git repository
+func (ExecBackend) IsGitRepo(ctx context.Context, path string) bool {
+	_, err := git(ctx, path, "rev-parse", "--git-dir")
+	return err == nil
+}
+
+// IsBareRepo checks if a directory is a bare repository
+func (ExecBackend) IsBareRepo(ctx context.Context, path string) bool {
+	bare, err := git(ctx, path, "rev-parse", "--is-bare-repository")
+	return err == nil && bare == "true"
+}
+
+// IsMirror checks if a repository was cloned as a mirror of its origin
+func (ExecBackend) IsMirror(ctx context.Context, path string) bool {
+	mirror, err := git(ctx, path, "config", "--bool", "remote.origin.mirror")
+	return err == nil && mirror == "true"
+}
+
+// IsWorktree checks if a directory is a worktree (not the main checkout)
+// Returns true if worktree, and the path to the main checkout relative to the worktree
+func (ExecBackend) IsWorktree(ctx context.Context, path string) (bool, string) {
+	// Get the worktree list in porcelain format
+	output, err := git(ctx, path, "worktree", "list", "--porcelain")
+	if err != nil {
+		return false, ""
+	}
+
+	// Parse the worktree list to find the main worktree and current path
+	absPath, _ := filepath.Abs(path)
+
+	var mainWorktreePath string
+	var currentIsWorktree bool
+
+	lines := strings.Split(output, "\n")
+	isFirstWorktree := true
+	var currentWorktreePath string
+
+	for _, line := range lines {
+		if strings.HasPrefix(line, "worktree ") {
+			currentWorktreePath = strings.TrimPrefix(line, "worktree ")
+			if isFirstWorktree {
+				// First worktree entry is always the main checkout
+				mainWorktreePath = currentWorktreePath
+				isFirstWorktree = false
+			}
+		}
+		// Empty line marks end of a worktree entry
+		if line == "" && currentWorktreePath != "" {
+			// Check if this worktree matches our path
+			if currentWorktreePath == absPath && currentWorktreePath != mainWorktreePath {
+				currentIsWorktree = true
+			}
+			currentWorktreePath = ""
+		}
+	}
+
+	// Handle case where output doesn't end with empty line
+	if currentWorktreePath != "" && currentWorktreePath == absPath && currentWorktreePath != mainWorktreePath {
+		currentIsWorktree = true
+	}
+
+	if !currentIsWorktree {
+		return false, ""
+	}
+
+	// Make main checkout path relative to the worktree
+	relPath, err := filepath.Rel(absPath, mainWorktreePath)
+	if err != nil {
+		return true, mainWorktreePath
+	}
+
+	return true, relPath
+}
+
+// Branch returns the current branch name, or "HEAD" if detached
+func (ExecBackend) Branch(ctx context.Context, path string) (string, error) {
+	return git(ctx, path, "rev-parse", "--abbrev-ref", "HEAD")
+}
+
+// shortCommit abbreviates a commit SHA for display
+func shortCommit(commit string) string {
+	if len(commit) > 12 {
+		return commit[:12]
+	}
+	return commit
+}
+
+// Commit returns the current HEAD commit SHA
+func (ExecBackend) Commit(ctx context.Context, path string) (string, error) {
+	return git(ctx, path, "rev-parse", "HEAD")
+}
+
+// RemoteURL returns the origin remote URL
+func (ExecBackend) RemoteURL(ctx context.Context, path string) (string, error) {
+	return git(ctx, path, "remote", "get-url", "origin")
+}
+
+// InsteadOf returns the url.<base>.insteadOf rules in git's config, which
+// rewrite URLs starting with the configured value to start with base
+func (ExecBackend) InsteadOf(ctx context.Context) ([]URLRewrite, error) {
+	output, err := git(ctx, "", "config", "--null", "--get-regexp", `^url\..*\.insteadof$`)
+	var gitErr *GitError
+	if errors.As(err, &gitErr) && gitErr.ExitCode == 1 {
+		// No rules are configured
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var rewrites []URLRewrite
+	for _, entry := range strings.Split(output, "\x00") {
+		if entry == "" {
+			continue
+		}
+		// Each entry is the key and value separated by a newline
+		key, value, _ := strings.Cut(entry, "\n")
+		base := strings.TrimSuffix(strings.TrimPrefix(key, "url."), ".insteadof")
+		rewrites = append(rewrites, URLRewrite{From: value, To: base})
+	}
+	return rewrites, nil
+}
+
+// HasUncommittedChanges checks if there are uncommitted changes
+func (ExecBackend) HasUncommittedChanges(ctx context.Context, path string) (bool, error) {
+	status, err := git(ctx, path, "status", "--porcelain")
+	if err != nil {
+		return false, err
+	}
+	return status != "", nil
+}
+
+// ShallowDepth returns the number of commits reachable from HEAD if the
+// repository is shallow, or 0 if it has full history
+func (ExecBackend) ShallowDepth(ctx context.Context, path string) int {
+	shallow, err := git(ctx, path, "rev-parse", "--is-shallow-repository")
+	if err != nil || shallow != "true" {
+		return 0
+	}
+	count, err := git(ctx, path, "rev-list", "--count", "HEAD")
+	if err != nil {
+		return 0
+	}
+	depth, err := strconv.Atoi(count)
+	if err != nil {
+		return 0
+	}
+	return depth
+}
+
+// PartialCloneFilter returns the partial clone filter of the origin
+// remote, or an empty string if the repository is not a partial clone
+func (ExecBackend) PartialCloneFilter(ctx context.Context, path string) string {
+	promisor, err := git(ctx, path, "config", "--bool", "remote.origin.promisor")
+	if err != nil || promisor != "true" {
+		return ""
+	}
+	filter, err := git(ctx, path, "config", "remote.origin.partialclonefilter")
+	if err != nil {
+		return ""
+	}
+	return filter
+}
+
+// LocalConfig returns the repository-local config entries matching filter.
+// Multi-valued keys keep their last value, matching git's own lookup.
+func (ExecBackend) LocalConfig(ctx context.Context, path string, filter ConfigFilter) map[string]string {
+	output, err := git(ctx, path, "config", "--local", "--null", "--list")
+	if err != nil {
+		return nil
+	}
+
+	config := make(map[string]string)
+	for _, entry := range strings.Split(output, "\x00") {
+		if entry == "" {
+			continue
+		}
+		// Each entry is the key and value separated by a newline
+		key, value, _ := strings.Cut(entry, "\n")
+		if filter.matches(key) {
+			config[key] = value
+		}
+	}
+
+	if len(config) == 0 {
+		return nil
+	}
+	return config
+}
+
+// SparseCheckout returns the sparse-checkout mode and patterns, or nil if
+// sparse-checkout is not enabled
+func (ExecBackend) SparseCheckout(ctx context.Context, path string) *SparseCheckout {
+	enabled, err := git(ctx, path, "config", "--bool", "core.sparseCheckout")
+	if err != nil || enabled != "true" {
+		return nil
+	}
+
+	cone, _ := git(ctx, path, "config", "--bool", "core.sparseCheckoutCone")
+
+	output, err := git(ctx, path, "sparse-checkout", "list")
+	if err != nil {
+		return nil
+	}
+
+	sparse := &SparseCheckout{
+		Cone:     cone == "true",
+		Patterns: []string{},
+	}
+	for _, line := range strings.Split(output, "\n") {
+		if line != "" {
+			sparse.Patterns = append(sparse.Patterns, line)
+		}
+	}
+	return sparse
+}
+
+// SetSparseCheckout enables sparse-checkout with the given mode and patterns
+func (ExecBackend) SetSparseCheckout(ctx context.Context, path string, sparse *SparseCheckout) error {
+	_, err := git(ctx, path, sparseCheckoutArgs(sparse)...)
+	return err
+}
+
+// sparseCheckoutArgs returns the git arguments that set sparse
+func sparseCheckoutArgs(sparse *SparseCheckout) []string {
+	args := []string{"sparse-checkout", "set"}
+	if sparse.Cone {
+		args = append(args, "--cone")
+	} else {
+		args = append(args, "--no-cone")
+	}
+	return append(args, sparse.Patterns...)
+}
+
+// CloneOptions controls how a repository is cloned
+type CloneOptions struct {
+	// NoCheckout skips checking out files after the clone
+	NoCheckout bool
+	// Depth creates a shallow clone with history truncated to this many
+	// commits when greater than zero
+	Depth int
+	// Filter creates a partial clone using this object filter when not empty
+	Filter string
+	// Bare creates a bare repository without a working tree
+	Bare bool
+	// Mirror creates a bare repository that mirrors all refs of the remote
+	Mirror bool
+	// Progress receives the progress git reports while cloning when not nil
+	Progress func(phase string, percent int)
+}
+
+// UsesLFS checks if a checkout uses Git LFS, either through LFS filters in
+// its attributes files or LFS settings in its config
+func (ExecBackend) UsesLFS(ctx context.Context, path string) bool {
+	if out, err := git(ctx, path, "config", "--local", "--get-regexp", `^(lfs\.|filter\.lfs\.)`); err == nil && out != "" {
+		return true
+	}
+	if _, err := os.Stat(filepath.Join(path, ".lfsconfig")); err == nil {
+		return true
+	}
+
+	files, err := git(ctx, path, "ls-files", "--", ":(glob)**/.gitattributes")
+	if err != nil {
+		return false
+	}
+	for _, f := range strings.Split(files, "\n") {
+		if f == "" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(path, f))
+		if err != nil {
+			continue
+		}
+		if strings.Contains(string(data), "filter=lfs") {
+			return true
+		}
+	}
+	return false
+}
+
+// HasLFS checks if the git-lfs extension is installed
+func (ExecBackend) HasLFS() bool {
+	_, err := exec.LookPath("git-lfs")
+	return err == nil
+}
+
+// PullLFS installs the LFS hooks in a repository and fetches the LFS objects
+// for the checked out commit, replacing pointer files with their content
+func (ExecBackend) PullLFS(ctx context.Context, path string) error {
+	if _, err := git(ctx, path, "lfs", "install", "--local"); err != nil {
+		return err
+	}
+	_, err := git(ctx, path, "lfs", "pull")
+	return err
+}
+
+// Clone clones a repository. Credentials in url are removed from the error
+// returned when the clone fails, so that it can be logged.
+func (ExecBackend) Clone(ctx context.Context, url, path string, opts CloneOptions) error {
+	_, err := gitWithProgress(ctx, "", opts.Progress, cloneArgs(url, path, opts)...)
+	var gitErr *GitError
+	if errors.As(err, &gitErr) {
+		if scrubbed, removed := scrubURL(url); len(removed) > 0 {
+			for i, arg := range gitErr.Args {
+				if arg == url {
+					gitErr.Args[i] = scrubbed
+				}
+			}
+			gitErr.Stderr = strings.ReplaceAll(gitErr.Stderr, url, scrubbed)
+		}
+	}
+	return err
+}
+
+// cloneArgs returns the git arguments that clone url to path with opts
+func cloneArgs(url, path string, opts CloneOptions) []string {
+	args := []string{"clone"}
+	if opts.NoCheckout {
+		args = append(args, "--no-checkout")
+	}
+	if opts.Depth > 0 {
+		// Shallow clones default to a single branch, but the captured
+		// branch may not be the default branch
+		args = append(args, "--depth", strconv.Itoa(opts.Depth), "--no-single-branch")
+	}
+	if opts.Filter != "" {
+		args = append(args, "--filter", opts.Filter)
+	}
+	if opts.Mirror {
+		args = append(args, "--mirror")
+	} else if opts.Bare {
+		args = append(args, "--bare")
+	}
+	if opts.Progress != nil {
+		args = append(args, "--progress")
+	}
+	// The URL and path come from state, so they must not be parsed as
+	// options
+	return append(args, "--", url, path)
+}
+
+// HasCommit checks if a commit exists in a repository
+func (ExecBackend) HasCommit(ctx context.Context, path, commit string) bool {
+	_, err := git(ctx, path, "cat-file", "-e", commit+"^{commit}")
+	return err == nil
+}
+
+// Fetch fetches a refspec, branch name, or commit SHA from origin
+func (ExecBackend) Fetch(ctx context.Context, path, refspec string) error {
+	_, err := git(ctx, path, "fetch", "--", "origin", refspec)
+	return err
+}
+
+// SetHead points HEAD of a bare repository at a branch
+func (ExecBackend) SetHead(ctx context.Context, path, branch string) error {
+	_, err := git(ctx, path, "symbolic-ref", "HEAD", "refs/heads/"+branch)
+	return err
+}
+
+// SetConfig sets repository-local config entries, in key order
+func (ExecBackend) SetConfig(ctx context.Context, path string, config map[string]string) error {
+	for _, args := range configArgs(config) {
+		if _, err := git(ctx, path, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// configArgs returns the git arguments that set each entry of config, in key
+// order
+func configArgs(config map[string]string) [][]string {
+	keys := make([]string, 0, len(config))
+	for k := range config {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	args := make([][]string, 0, len(keys))
+	for _, k := range keys {
+		args = append(args, []string{"config", "--local", "--", k, config[k]})
+	}
+	return args
+}
+
+// Checkout checks out a specific branch and resets to a commit. The reset
+// also populates the working tree of clones made with --no-checkout.
+func (ExecBackend) Checkout(ctx context.Context, path, branch, commit string) error {
+	// Try to checkout the branch first
+	if branch != "" && branch != "HEAD" {
+		// Try checking out existing branch
+		if _, err := git(ctx, path, "checkout", branch); err != nil {
+			// Branch doesn't exist locally, create it
+			if _, err := git(ctx, path, "checkout", "-b", branch); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Reset to the specific commit
+	if commit != "" {
+		_, err := git(ctx, path, "reset", "--hard", commit)
+		return err
+	}
+	return nil
+}
+
+// CheckoutDetached detaches HEAD at a specific commit without moving any
+// branch, and resets the working tree to it
+func (ExecBackend) CheckoutDetached(ctx context.Context, path, commit string) error {
+	if commit == "" {
+		commit = "HEAD"
+	}
+	if _, err := git(ctx, path, "update-ref", "--no-deref", "HEAD", commit); err != nil {
+		return err
+	}
+	_, err := git(ctx, path, "reset", "--hard", "HEAD")
+	return err
+}
+
+// AddWorktree adds a new worktree, applying sparse-checkout patterns before
+// any files are checked out when sparse is not nil. A detached worktree is
+// created at commit instead of checking out branch.
+func (b ExecBackend) AddWorktree(ctx context.Context, mainPath, worktreePath, branch, commit string, detached bool, sparse *SparseCheckout) error {
+	args := []string{"worktree", "add"}
+	if sparse != nil {
+		args = append(args, "--no-checkout")
+	}
+
+	if detached {
+		args = append(args, "--detach", worktreePath)
+		if commit != "" {
+			args = append(args, commit)
+		}
+		if _, err := git(ctx, mainPath, args...); err != nil {
+			return err
+		}
+		if sparse != nil {
+			if err := b.SetSparseCheckout(ctx, worktreePath, sparse); err != nil {
+				return err
+			}
+			// Nothing is checked out yet
+			_, err := git(ctx, worktreePath, "reset", "--hard", "HEAD")
+			return err
+		}
+		return nil
+	}
+
+	// Create the worktree at the specified branch
+	if _, err := git(ctx, mainPath, append(args, worktreePath, branch)...); err != nil {
+		// If branch doesn't exist, create it
+		if _, err := git(ctx, mainPath, append(args, "-b", branch, worktreePath)...); err != nil {
+			return err
+		}
+	}
+
+	if sparse != nil {
+		if err := b.SetSparseCheckout(ctx, worktreePath, sparse); err != nil {
+			return err
+		}
+		// Nothing is checked out yet, so a reset is always needed
+		if commit == "" {
+			commit = "HEAD"
+		}
+	}
+
+	// Reset to the specific commit if provided
+	if commit != "" {
+		_, err := git(ctx, worktreePath, "reset", "--hard", commit)
+		return err
+	}
+	return nil
+}
+
+// RemoveWorktree removes a worktree, falling back to deleting its directory
+// and pruning stale worktree entries if git cannot remove it
+func (ExecBackend) RemoveWorktree(ctx context.Context, mainPath, worktreePath string) error {
+	if _, err := git(ctx, mainPath, "worktree", "remove", "--force", worktreePath); err == nil {
+		return nil
+	}
+	if err := os.RemoveAll(worktreePath); err != nil {
+		return err
+	}
+	_, err := git(ctx, mainPath, "worktree", "prune")
+	return err
+}
@@ -0,0 +1,153 @@
+package gate
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// Journal step statuses
+const (
+	journalStarted = "started"
+	journalDone    = "done"
+	journalFailed  = "failed"
+)
+
+// journalRepository is the step recorded around all the steps of setting up
+// a single repository
+const journalRepository = "repository"
+
+// journalEntry is a single line of the journal
+type journalEntry struct {
+	Path   string `json:"path"`
+	Step   string `json:"step"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// journal records the steps apply takes for each repository as JSON lines,
+// so that an interrupted apply can be resumed. A nil journal records nothing.
+type journal struct {
+	path string
+	f    *os.File
+	enc  *json.Encoder
+	// last is the last status of each step for each path that was in the
+	// journal when it was opened
+	last map[string]map[string]string
+}
+
+// openJournal opens the journal at path. When resume is true the existing
+// entries are read and new entries are appended, otherwise the journal is
+// started afresh.
+func openJournal(path string, resume bool) (*journal, error) {
+	j := &journal{path: path, last: map[string]map[string]string{}}
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if resume {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+		if err := j.read(path); err != nil {
+			return nil, fmt.Errorf("failed to read journal: %w", err)
+		}
+	}
+
+	f, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+	j.f = f
+	j.enc = json.NewEncoder(f)
+	return j, nil
+}
+
+// read loads the repository statuses from an existing journal
+func (j *journal) read(path string) error {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// A line cut short by a crash is ignored
+			continue
+		}
+		if j.last[entry.Path] == nil {
+			j.last[entry.Path] = map[string]string{}
+		}
+		j.last[entry.Path][entry.Step] = entry.Status
+	}
+	return scanner.Err()
+}
+
+// status returns the last status a previous apply recorded for a step of
+// setting up path, and whether it recorded the step at all
+func (j *journal) status(path, step string) (string, bool) {
+	if j == nil {
+		return "", false
+	}
+	status, ok := j.last[path][step]
+	return status, ok
+}
+
+// completed reports whether a previous apply finished setting up path
+func (j *journal) completed(path string) bool {
+	status, _ := j.status(path, journalRepository)
+	return status == journalDone
+}
+
+// incomplete reports whether a previous apply started setting up path but did
+// not finish
+func (j *journal) incomplete(path string) bool {
+	status, ok := j.status(path, journalRepository)
+	return ok && status != journalDone
+}
+
+// record appends an entry for a step of setting up path
+func (j *journal) record(path, step, status string, err error) {
+	if j == nil {
+		return
+	}
+	entry := journalEntry{Path: path, Step: step, Status: status}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	j.enc.Encode(entry)
+}
+
+// step records a step as started, runs fn, and records whether it succeeded
+func (j *journal) step(path, step string, fn func() error) error {
+	j.record(path, step, journalStarted, nil)
+	if err := fn(); err != nil {
+		j.record(path, step, journalFailed, err)
+		return err
+	}
+	j.record(path, step, journalDone, nil)
+	return nil
+}
+
+// Close closes the journal file. Closing a removed journal does nothing.
+func (j *journal) Close() error {
+	if j == nil || j.f == nil {
+		return nil
+	}
+	err := j.f.Close()
+	j.f = nil
+	return err
+}
+
+// remove closes and deletes the journal file
+func (j *journal) remove() error {
+	if err := j.Close(); err != nil {
+		return err
+	}
+	return os.Remove(j.path)
+}
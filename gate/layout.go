@@ -0,0 +1,144 @@
+package gate
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Layout chooses where Apply places repositories
+type Layout string
+
+const (
+	// LayoutCaptured places repositories at the paths recorded in state
+	LayoutCaptured Layout = "captured"
+	// LayoutGhq places each main checkout at host/owner/name under
+	// Options.LayoutRoot, derived from its remote URL the way ghq lays out
+	// repositories, regardless of where it was captured. Worktrees keep the
+	// same place relative to their main checkout.
+	LayoutGhq Layout = "ghq"
+)
+
+// ParseLayout parses the name of a layout
+func ParseLayout(name string) (Layout, error) {
+	switch l := Layout(strings.ToLower(name)); l {
+	case LayoutCaptured, LayoutGhq:
+		return l, nil
+	}
+	return "", fmt.Errorf("unknown layout %q: must be captured or ghq", name)
+}
+
+// splitRemoteURL splits a remote URL in the URL form, e.g.
+// https://github.com/owner/name.git or ssh://git@github.com/owner/name, or
+// the scp-like form, e.g. git@github.com:owner/name.git, into its host and
+// path. The host is empty for local paths.
+func splitRemoteURL(remoteURL string) (host, urlPath string) {
+	if strings.Contains(remoteURL, "://") {
+		u, err := url.Parse(remoteURL)
+		if err != nil {
+			return "", ""
+		}
+		return u.Hostname(), u.Path
+	}
+	// scp-like syntax only has a colon before any slash
+	before, after, ok := strings.Cut(remoteURL, ":")
+	if !ok || strings.Contains(before, "/") {
+		return "", remoteURL
+	}
+	if i := strings.LastIndex(before, "@"); i >= 0 {
+		before = before[i+1:]
+	}
+	return before, after
+}
+
+// layoutPath derives the host/owner/name path of a repository from its
+// remote URL, e.g. github.com/owner/name for git@github.com:owner/name.git
+func layoutPath(remoteURL string) (string, error) {
+	host, urlPath := splitRemoteURL(remoteURL)
+	host = strings.ToLower(host)
+	if host == "" || strings.ContainsAny(host, `/\`) || host == "." || host == ".." {
+		return "", fmt.Errorf("remote URL %s has no host", remoteURL)
+	}
+
+	// Home directory paths, e.g. ssh://host/~user/name, are laid out by user
+	urlPath = strings.Trim(urlPath, "/")
+	urlPath = strings.TrimPrefix(urlPath, "~")
+	urlPath = strings.TrimSuffix(strings.TrimSuffix(urlPath, "/"), ".git")
+	if urlPath == "" {
+		return "", fmt.Errorf("remote URL %s has no repository path", remoteURL)
+	}
+	for _, segment := range strings.Split(urlPath, "/") {
+		if segment == "" || segment == "." || segment == ".." || strings.Contains(segment, `\`) {
+			return "", fmt.Errorf("remote URL %s has an invalid repository path", remoteURL)
+		}
+	}
+	return filepath.FromSlash(path.Join(host, urlPath)), nil
+}
+
+// layoutRepositories returns repos with the paths that opts.Layout places
+// them at, and the reason for each repository that cannot be placed, by
+// index. Main checkouts must come before their worktrees, as applyOrder
+// sorts them. Worktrees of main checkouts that are not in repos stay where
+// they are.
+func layoutRepositories(repos []Repository, opts Options) ([]Repository, []error) {
+	placed := make([]Repository, len(repos))
+	errs := make([]error, len(repos))
+	// mains maps the paths of main checkouts in state to where they are placed
+	mains := map[string]string{}
+	// taken maps the paths repositories are placed at to their paths in state
+	taken := map[string]string{}
+	place := func(i int, p string) bool {
+		if other, ok := taken[p]; ok {
+			errs[i] = fmt.Errorf("layout places %s at %s, where %s is placed", repos[i].Path, p, other)
+			return false
+		}
+		taken[p] = repos[i].Path
+		placed[i].Path = p
+		return true
+	}
+
+	for i, repo := range repos {
+		placed[i] = repo
+		if opts.Layout == LayoutCaptured || opts.Layout == "" {
+			continue
+		}
+
+		if !repo.IsWorktree {
+			p, err := layoutPath(repo.RemoteURL)
+			if err != nil {
+				errs[i] = fmt.Errorf("failed to lay out: %w", err)
+				continue
+			}
+			if place(i, filepath.Join(opts.LayoutRoot, p)) {
+				mains[filepath.Clean(repo.Path)] = placed[i].Path
+			}
+			continue
+		}
+
+		if repo.MainCheckoutPath == nil {
+			continue
+		}
+		mainPath := worktreeMainPath(repo.Path, repo)
+		newMainPath, ok := mains[mainPath]
+		if !ok {
+			continue
+		}
+		rel, err := filepath.Rel(mainPath, repo.Path)
+		if err != nil {
+			errs[i] = fmt.Errorf("failed to lay out: %w", err)
+			continue
+		}
+		if place(i, filepath.Join(newMainPath, rel)) {
+			// MainCheckoutPath is relative to the worktree path
+			mainCheckoutPath, err := filepath.Rel(placed[i].Path, newMainPath)
+			if err != nil {
+				errs[i] = fmt.Errorf("failed to lay out: %w", err)
+				continue
+			}
+			placed[i].MainCheckoutPath = &mainCheckoutPath
+		}
+	}
+	return placed, errs
+}
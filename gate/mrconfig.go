@@ -0,0 +1,229 @@
+package gate
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// mrSection is a repository section of a myrepos .mrconfig file
+type mrSection struct {
+	path     string
+	checkout string
+}
+
+// parseMrconfig reads the sections of a .mrconfig file that have a checkout
+// command. Values continue onto following lines that start with whitespace.
+func parseMrconfig(data []byte) ([]mrSection, error) {
+	var sections []mrSection
+	var current *mrSection
+	var key string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "" || strings.HasPrefix(trimmed, "#"):
+			continue
+		case line[0] == ' ' || line[0] == '\t':
+			if current != nil && key == "checkout" {
+				current.checkout += "\n" + trimmed
+			}
+		case strings.HasPrefix(trimmed, "["):
+			if !strings.HasSuffix(trimmed, "]") {
+				return nil, fmt.Errorf("line %d: invalid section %q", n, trimmed)
+			}
+			sections = append(sections, mrSection{path: strings.TrimSpace(trimmed[1 : len(trimmed)-1])})
+			current = &sections[len(sections)-1]
+			key = ""
+		default:
+			k, v, ok := strings.Cut(trimmed, "=")
+			if !ok {
+				return nil, fmt.Errorf("line %d: expected key = value, got %q", n, trimmed)
+			}
+			key = strings.TrimSpace(k)
+			if current != nil && key == "checkout" {
+				current.checkout = strings.TrimSpace(v)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return sections, nil
+}
+
+// ImportMrconfig converts a myrepos .mrconfig file to state. Each section's
+// checkout command must be a plain git clone, optionally followed by
+// checking out a commit in the clone, as written by ExportMrconfig. Sections
+// with any other checkout command, such as ones that run other commands or
+// use shell variables, are skipped and a warning describing each is
+// returned.
+func ImportMrconfig(data []byte) (*State, []string, error) {
+	sections, err := parseMrconfig(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse .mrconfig: %w", err)
+	}
+
+	state := &State{}
+	var warnings []string
+	for _, s := range sections {
+		if s.path == "DEFAULT" {
+			continue
+		}
+		if s.checkout == "" {
+			warnings = append(warnings, fmt.Sprintf("%s: no checkout command, skipped", s.path))
+			continue
+		}
+		if filepath.IsAbs(s.path) || strings.HasPrefix(s.path, "~") {
+			warnings = append(warnings, fmt.Sprintf("%s: only paths relative to the .mrconfig are supported, skipped", s.path))
+			continue
+		}
+		repo, err := parseMrCheckout(s.path, s.checkout)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s: checkout command %q is not supported: %v, skipped", s.path, s.checkout, err))
+			continue
+		}
+		state.Repositories = append(state.Repositories, repo)
+	}
+	return state, warnings, nil
+}
+
+// parseMrCheckout parses a checkout command of the form
+// "git clone [options] URL [dir] [&& git -C dir checkout [-q] commit]"
+func parseMrCheckout(path, checkout string) (Repository, error) {
+	commands, err := shellSplit(checkout)
+	if err != nil {
+		return Repository{}, err
+	}
+	if len(commands) > 2 {
+		return Repository{}, fmt.Errorf("more than two commands")
+	}
+
+	clone := commands[0]
+	if len(clone) < 3 || clone[0] != "git" || clone[1] != "clone" {
+		return Repository{}, fmt.Errorf("not a git clone")
+	}
+	repo := Repository{Path: path}
+	var args []string
+	for i := 2; i < len(clone); i++ {
+		arg := clone[i]
+		// Options that take a value, as separate words or joined by =
+		name, value, joined := strings.Cut(arg, "=")
+		takesValue := name == "-b" || name == "--branch" || name == "--depth" || name == "--filter"
+		if takesValue && !joined {
+			if i+1 >= len(clone) {
+				return Repository{}, fmt.Errorf("%s needs a value", name)
+			}
+			i++
+			value = clone[i]
+		}
+		switch {
+		case name == "-b" || name == "--branch":
+			repo.Branch = value
+		case name == "--depth":
+			depth, err := strconv.Atoi(value)
+			if err != nil {
+				return Repository{}, fmt.Errorf("invalid depth %q", value)
+			}
+			repo.Depth = depth
+		case name == "--filter":
+			repo.Filter = value
+		case arg == "--bare":
+			repo.Bare = true
+		case arg == "--mirror":
+			repo.Bare = true
+			repo.Mirror = true
+		case arg == "-q" || arg == "--quiet" || arg == "--recursive" || arg == "--recurse-submodules":
+			// Options that don't change the resulting state
+		case strings.HasPrefix(arg, "-"):
+			return Repository{}, fmt.Errorf("unsupported option %s", arg)
+		default:
+			args = append(args, arg)
+		}
+	}
+	if len(args) == 0 || len(args) > 2 {
+		return Repository{}, fmt.Errorf("expected a URL and optional directory")
+	}
+	repo.RemoteURL = args[0]
+	dir := filepath.Base(path)
+	if len(args) == 2 {
+		// The checkout command runs in the parent of the section's path
+		dir = args[1]
+		repo.Path = filepath.Join(filepath.Dir(path), dir)
+	}
+
+	if len(commands) == 2 {
+		co := commands[1]
+		if len(co) == 6 && co[4] == "-q" {
+			co = append(co[:4:4], co[5])
+		}
+		if len(co) != 5 || co[0] != "git" || co[1] != "-C" || co[2] != dir || co[3] != "checkout" || !commitPattern.MatchString(co[4]) {
+			return Repository{}, fmt.Errorf("second command is not a checkout of a commit in the clone")
+		}
+		repo.Commit = co[4]
+		if repo.Branch == "" {
+			repo.Branch = "HEAD"
+			repo.Detached = true
+		}
+	}
+	return repo, nil
+}
+
+// ExportMrconfig converts state to a myrepos .mrconfig file, to be placed in
+// the directory the paths in state are relative to. Each repository is
+// cloned on its branch, and a commit is checked out in the clone when it is
+// detached or when opts.Exact is set. Details that .mrconfig checkout
+// commands cannot represent, such as worktrees, are left out, and a warning
+// describing each is returned.
+func ExportMrconfig(state *State, opts ExportOptions) ([]byte, []string, error) {
+	var buf bytes.Buffer
+	var warnings []string
+	for _, repo := range state.Repositories {
+		if repo.IsWorktree {
+			warnings = append(warnings, fmt.Sprintf("%s: worktrees are not supported by myrepos, skipped", repo.Path))
+			continue
+		}
+		if repo.RemoteURL == "" {
+			warnings = append(warnings, fmt.Sprintf("%s: no remote URL, skipped", repo.Path))
+			continue
+		}
+
+		// Clone options represent these
+		lossy := repo
+		lossy.Depth, lossy.Filter, lossy.Bare, lossy.Mirror = 0, "", false, false
+		warnings = append(warnings, lossyFields(lossy, "myrepos")...)
+
+		dir := filepath.Base(repo.Path)
+		args := []string{"git", "clone"}
+		switch {
+		case repo.Mirror:
+			args = append(args, "--mirror")
+		case repo.Bare:
+			args = append(args, "--bare")
+		}
+		if !repo.isDetached() && repo.Branch != "" {
+			args = append(args, "-b", repo.Branch)
+		}
+		if repo.Depth > 0 {
+			args = append(args, "--depth", strconv.Itoa(repo.Depth))
+		}
+		if repo.Filter != "" {
+			args = append(args, "--filter", repo.Filter)
+		}
+		args = append(args, repo.RemoteURL, dir)
+		checkout := shellJoin(args...)
+		if !repo.Bare && repo.Commit != "" && (repo.isDetached() || opts.Exact) {
+			checkout += " && " + shellJoin("git", "-C", dir, "checkout", "-q", repo.Commit)
+		}
+
+		if buf.Len() > 0 {
+			buf.WriteByte('\n')
+		}
+		fmt.Fprintf(&buf, "[%s]\ncheckout = %s\n", repo.Path, checkout)
+	}
+	return buf.Bytes(), warnings, nil
+}
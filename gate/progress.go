@@ -0,0 +1,97 @@
+package gate
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+)
+
+// EventKind identifies what a progress Event reports
+type EventKind int
+
+const (
+	// EventScan is sent for each directory Capture checks for a repository
+	EventScan EventKind = iota
+	// EventFound is sent when Capture finds a repository
+	EventFound
+	// EventRepoStart is sent when Apply starts setting up a repository
+	EventRepoStart
+	// EventGitProgress is sent when git reports how far through an operation
+	// it is, e.g. while cloning
+	EventGitProgress
+	// EventRepoDone is sent when Apply finishes a repository, whether or not
+	// it succeeded
+	EventRepoDone
+)
+
+// Event reports progress during Capture or Apply
+type Event struct {
+	Kind EventKind
+	// Path is the directory being scanned for EventScan, otherwise the
+	// repository path relative to Options.Dir
+	Path string
+	// Count is the number of repositories Capture has found, or the position
+	// of the repository Apply is setting up
+	Count int
+	// Total is the number of repositories Apply is setting up
+	Total int
+	// Phase is what git is doing for EventGitProgress, e.g. "Receiving
+	// objects", and Percent is how far through it git is
+	Phase   string
+	Percent int
+	// Status and Err are the outcome of the repository for EventRepoDone
+	Status Status
+	Err    error
+}
+
+// progress sends ev to opts.Progress when it is set
+func (opts Options) progress(ev Event) {
+	if opts.Progress != nil {
+		opts.Progress(ev)
+	}
+}
+
+// gitProgressPattern matches a progress line written by git with --progress,
+// e.g. "Receiving objects:  45% (450/1000), 1.20 MiB | 2.00 MiB/s"
+var gitProgressPattern = regexp.MustCompile(`^(?:remote: )?([A-Za-z][A-Za-z ]*):\s+(\d+)%`)
+
+// progressWriter receives git's stderr, passing progress lines to fn and
+// keeping all other lines in buf so that they can be reported in errors
+type progressWriter struct {
+	buf     *bytes.Buffer
+	fn      func(phase string, percent int)
+	partial []byte
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+	for {
+		// git redraws progress with \r and ends each phase with \n
+		i := bytes.IndexAny(w.partial, "\r\n")
+		if i < 0 {
+			break
+		}
+		w.line(w.partial[:i])
+		w.partial = w.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// flush handles a final line that was not terminated
+func (w *progressWriter) flush() {
+	w.line(w.partial)
+	w.partial = nil
+}
+
+func (w *progressWriter) line(line []byte) {
+	if len(bytes.TrimSpace(line)) == 0 {
+		return
+	}
+	if m := gitProgressPattern.FindSubmatch(line); m != nil {
+		percent, _ := strconv.Atoi(string(m[2]))
+		w.fn(string(m[1]), percent)
+		return
+	}
+	w.buf.Write(line)
+	w.buf.WriteByte('\n')
+}
@@ -0,0 +1,230 @@
+package gate
+
+import (
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// manifest is a manifest for Google's repo tool
+type manifest struct {
+	XMLName  xml.Name          `xml:"manifest"`
+	Remotes  []manifestRemote  `xml:"remote"`
+	Default  *manifestDefault  `xml:"default"`
+	Projects []manifestProject `xml:"project"`
+	Includes []manifestInclude `xml:"include"`
+}
+
+type manifestRemote struct {
+	Name     string `xml:"name,attr"`
+	Fetch    string `xml:"fetch,attr"`
+	Revision string `xml:"revision,attr,omitempty"`
+}
+
+type manifestDefault struct {
+	Remote   string `xml:"remote,attr,omitempty"`
+	Revision string `xml:"revision,attr,omitempty"`
+}
+
+type manifestProject struct {
+	Name       string `xml:"name,attr"`
+	Path       string `xml:"path,attr,omitempty"`
+	Remote     string `xml:"remote,attr,omitempty"`
+	Revision   string `xml:"revision,attr,omitempty"`
+	Upstream   string `xml:"upstream,attr,omitempty"`
+	CloneDepth string `xml:"clone-depth,attr,omitempty"`
+	Copyfiles  []struct {
+		Src string `xml:"src,attr"`
+	} `xml:"copyfile"`
+	Linkfiles []struct {
+		Src string `xml:"src,attr"`
+	} `xml:"linkfile"`
+}
+
+type manifestInclude struct {
+	Name string `xml:"name,attr"`
+}
+
+// ImportRepoManifest converts a manifest for Google's repo tool to state.
+// Each project is cloned from its remote's fetch URL joined with its name,
+// and checked out at its revision: a commit hash is checked out as a
+// detached HEAD, or on its upstream branch when it has one, and any other
+// revision is checked out by name as a branch or tag. Anything that cannot be
+// imported, such as includes or remotes with relative fetch URLs, is skipped
+// and a warning describing each is returned.
+func ImportRepoManifest(data []byte) (*State, []string, error) {
+	var m manifest
+	if err := xml.Unmarshal(data, &m); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse repo manifest: %w", err)
+	}
+
+	var warnings []string
+	for _, inc := range m.Includes {
+		warnings = append(warnings, fmt.Sprintf("include %s is not supported, skipped", inc.Name))
+	}
+
+	remotes := map[string]manifestRemote{}
+	for _, r := range m.Remotes {
+		remotes[r.Name] = r
+	}
+	var def manifestDefault
+	if m.Default != nil {
+		def = *m.Default
+	}
+
+	state := &State{Repositories: make([]Repository, 0, len(m.Projects))}
+	for _, p := range m.Projects {
+		path := p.Path
+		if path == "" {
+			path = p.Name
+		}
+
+		remoteName := p.Remote
+		if remoteName == "" {
+			remoteName = def.Remote
+		}
+		remote, ok := remotes[remoteName]
+		if !ok {
+			warnings = append(warnings, fmt.Sprintf("%s: remote %q is not defined, skipped", path, remoteName))
+			continue
+		}
+		if !isAbsoluteFetchURL(remote.Fetch) {
+			warnings = append(warnings, fmt.Sprintf("%s: fetch URL %q of remote %s is relative to the manifest URL, skipped", path, remote.Fetch, remote.Name))
+			continue
+		}
+
+		repo := Repository{
+			Path:      path,
+			RemoteURL: strings.TrimSuffix(remote.Fetch, "/") + "/" + p.Name,
+		}
+
+		revision := p.Revision
+		if revision == "" {
+			revision = remote.Revision
+		}
+		if revision == "" {
+			revision = def.Revision
+		}
+		switch {
+		case commitPattern.MatchString(revision) && p.Upstream != "":
+			repo.Branch = strings.TrimPrefix(p.Upstream, "refs/heads/")
+			repo.Commit = revision
+		case commitPattern.MatchString(revision):
+			repo.Branch = "HEAD"
+			repo.Commit = revision
+			repo.Detached = true
+		default:
+			repo.Branch = strings.TrimPrefix(strings.TrimPrefix(revision, "refs/heads/"), "refs/tags/")
+		}
+
+		if p.CloneDepth != "" {
+			depth, err := strconv.Atoi(p.CloneDepth)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: invalid clone-depth %q", path, p.CloneDepth)
+			}
+			repo.Depth = depth
+		}
+		if len(p.Copyfiles) > 0 || len(p.Linkfiles) > 0 {
+			warnings = append(warnings, fmt.Sprintf("%s: copyfile and linkfile are not supported, skipped", path))
+		}
+
+		state.Repositories = append(state.Repositories, repo)
+	}
+
+	sort.Slice(state.Repositories, func(i, j int) bool {
+		return state.Repositories[i].Path < state.Repositories[j].Path
+	})
+	return state, warnings, nil
+}
+
+// isAbsoluteFetchURL checks if a remote's fetch URL can be used without
+// knowing the URL the manifest was fetched from, which relative fetch URLs
+// such as ".." are resolved against
+func isAbsoluteFetchURL(fetch string) bool {
+	return fetch != "" && !strings.HasPrefix(fetch, ".")
+}
+
+// ExportRepoManifest converts state to a manifest for Google's repo tool. A
+// remote is defined for each distinct base URL that repositories are cloned
+// from. Each project's revision is its branch, or its commit when detached
+// or when opts.Exact is set, with the branch kept as its upstream. Details
+// that repo manifests cannot represent, such as worktrees, are left out, and
+// a warning describing each is returned.
+func ExportRepoManifest(state *State, opts ExportOptions) ([]byte, []string, error) {
+	m := manifest{}
+	var warnings []string
+	// remoteNames maps fetch URLs to the names of their remotes
+	remoteNames := map[string]string{}
+	usedNames := map[string]bool{}
+	for _, repo := range state.Repositories {
+		if repo.IsWorktree {
+			warnings = append(warnings, fmt.Sprintf("%s: worktrees are not supported by repo manifests, skipped", repo.Path))
+			continue
+		}
+		if repo.RemoteURL == "" {
+			warnings = append(warnings, fmt.Sprintf("%s: no remote URL, skipped", repo.Path))
+			continue
+		}
+		i := strings.LastIndex(repo.RemoteURL, "/")
+		if i <= 0 || i == len(repo.RemoteURL)-1 {
+			warnings = append(warnings, fmt.Sprintf("%s: remote URL %s cannot be split into a fetch URL and name, skipped", repo.Path, repo.RemoteURL))
+			continue
+		}
+		fetch, name := repo.RemoteURL[:i], repo.RemoteURL[i+1:]
+
+		// Shallow clones are represented by clone-depth
+		lossy := repo
+		lossy.Depth = 0
+		warnings = append(warnings, lossyFields(lossy, "repo manifests")...)
+
+		remoteName, ok := remoteNames[fetch]
+		if !ok {
+			remoteName = uniqueRemoteName(fetch, usedNames)
+			remoteNames[fetch] = remoteName
+			usedNames[remoteName] = true
+			m.Remotes = append(m.Remotes, manifestRemote{Name: remoteName, Fetch: fetch})
+		}
+
+		p := manifestProject{Name: name, Remote: remoteName}
+		if repo.Path != name {
+			p.Path = repo.Path
+		}
+		switch {
+		case repo.isDetached():
+			p.Revision = repo.Commit
+		case opts.Exact && repo.Commit != "":
+			p.Revision = repo.Commit
+			p.Upstream = repo.Branch
+		default:
+			p.Revision = repo.Branch
+		}
+		if repo.Depth > 0 {
+			p.CloneDepth = strconv.Itoa(repo.Depth)
+		}
+		m.Projects = append(m.Projects, p)
+	}
+
+	data, err := xml.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, nil, err
+	}
+	return append(append([]byte(xml.Header), data...), '\n'), warnings, nil
+}
+
+// uniqueRemoteName names a remote after the host of its fetch URL, e.g.
+// "github" for https://github.com/user, numbering names that are taken
+func uniqueRemoteName(fetch string, used map[string]bool) string {
+	host, _ := splitRemoteURL(fetch)
+	name, _, _ := strings.Cut(host, ".")
+	if name == "" {
+		name = "origin"
+	}
+
+	candidate := name
+	for i := 2; used[candidate]; i++ {
+		candidate = name + strconv.Itoa(i)
+	}
+	return candidate
+}
@@ -0,0 +1,94 @@
+package gate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// maxRetryDelay caps the exponential backoff between retries
+const maxRetryDelay = time.Minute
+
+// permanentErrors are fragments of git's stderr for failures that will not
+// succeed if retried. They take precedence over retryableErrors, because git
+// often reports an HTTP 404 or auth failure as a generic transfer error too.
+var permanentErrors = []string{
+	"authentication failed",
+	"permission denied",
+	"could not read username",
+	"could not read password",
+	"repository not found",
+	"does not exist",
+	"not found",
+	"couldn't find remote ref",
+	"invalid refspec",
+	"returned error: 401",
+	"returned error: 403",
+	"returned error: 404",
+}
+
+// retryableErrors are fragments of git's stderr for transient network
+// failures that may succeed if retried
+var retryableErrors = []string{
+	"connection reset",
+	"connection refused",
+	"timed out",
+	"could not resolve host",
+	"temporary failure in name resolution",
+	"early eof",
+	"the remote end hung up unexpectedly",
+	"rpc failed",
+	"unexpected disconnect",
+	"gnutls",
+	"ssl_read",
+	"tls connection",
+	"returned error: 429",
+	"returned error: 500",
+	"returned error: 502",
+	"returned error: 503",
+	"returned error: 504",
+}
+
+// isRetryable checks if err is a transient network failure, based on what git
+// reported on stderr
+func isRetryable(err error) bool {
+	var gitErr *GitError
+	if !errors.As(err, &gitErr) {
+		return false
+	}
+	stderr := strings.ToLower(gitErr.Stderr)
+	for _, s := range permanentErrors {
+		if strings.Contains(stderr, s) {
+			return false
+		}
+	}
+	for _, s := range retryableErrors {
+		if strings.Contains(stderr, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// withRetry runs fn, retrying it up to opts.Retries times with exponential
+// backoff starting at opts.RetryDelay while it fails with a retryable error
+func withRetry(ctx context.Context, desc string, opts Options, fn func() error) error {
+	delay := opts.RetryDelay
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt > opts.Retries || !isRetryable(err) {
+			return err
+		}
+
+		opts.Logger.Info(fmt.Sprintf("  %s failed, retrying in %s (attempt %d of %d): %v", desc, delay, attempt+1, opts.Retries+1, err), "event", "retry", "step", desc, "attempt", attempt+1, "error", err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+
+		delay = min(delay*2, maxRetryDelay)
+	}
+}
@@ -0,0 +1,79 @@
+package gate
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+)
+
+// URLRewrite replaces the From prefix of remote URLs with To, e.g. to clone
+// over HTTPS instead of SSH, or from a mirror
+type URLRewrite struct {
+	From string
+	To   string
+}
+
+// ParseURLRewrite parses a rewrite rule written as from=to, e.g.
+// "git@github.com:=https://github.com/"
+func ParseURLRewrite(rule string) (URLRewrite, error) {
+	from, to, ok := strings.Cut(rule, "=")
+	if !ok || from == "" {
+		return URLRewrite{}, fmt.Errorf("invalid rewrite %q: must be old=new", rule)
+	}
+	return URLRewrite{From: from, To: to}, nil
+}
+
+// ParseURLRewrites parses a list of rewrite rules, one from=to rule per line.
+// Blank lines and lines starting with # are ignored.
+func ParseURLRewrites(data []byte) ([]URLRewrite, error) {
+	var rewrites []URLRewrite
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rw, err := ParseURLRewrite(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		rewrites = append(rewrites, rw)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rewrites, nil
+}
+
+// rewriteURL applies the rewrite with the longest matching prefix to
+// remoteURL, the same way git chooses between url.<base>.insteadOf rules.
+// The first of equally long matches wins.
+func rewriteURL(remoteURL string, rewrites []URLRewrite) string {
+	best := -1
+	for i, rw := range rewrites {
+		if strings.HasPrefix(remoteURL, rw.From) && (best < 0 || len(rw.From) > len(rewrites[best].From)) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return remoteURL
+	}
+	return rewrites[best].To + strings.TrimPrefix(remoteURL, rewrites[best].From)
+}
+
+// withInsteadOf returns opts with the url.<base>.insteadOf rules from git's
+// config added after opts.Rewrites when opts.GitInsteadOf is set
+func (opts Options) withInsteadOf(ctx context.Context) (Options, error) {
+	if !opts.GitInsteadOf {
+		return opts, nil
+	}
+	insteadOf, err := opts.Git.InsteadOf(ctx)
+	if err != nil {
+		return opts, fmt.Errorf("failed to read url.<base>.insteadOf config: %w", err)
+	}
+	opts.Logger.Debug(fmt.Sprintf("using %d url.<base>.insteadOf rules from git config", len(insteadOf)))
+	opts.Rewrites = append(opts.Rewrites[:len(opts.Rewrites):len(opts.Rewrites)], insteadOf...)
+	return opts, nil
+}
@@ -0,0 +1,260 @@
+package gate
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// scriptHeader starts a restore script with the helpers its steps use
+const scriptHeader = `#!/bin/sh
+# Sets up git repositories the way gate apply would, relative to the
+# directory given as the first argument, or the current directory.
+# Paths that already exist are skipped. Repositories that fail to set up are
+# reported and the rest are still set up, and the script exits non-zero.
+
+set -u
+
+cd -- "${1:-.}" || exit 1
+root=$(pwd) || exit 1
+failed=0
+
+# log prints a message to stderr
+log() {
+	printf '%s\n' "$*" >&2
+}
+
+# skip_existing warns and returns true when path $1 already exists
+skip_existing() {
+	if [ -e "$1" ] || [ -L "$1" ]; then
+		log "warning: $1 already exists, skipping"
+		return 0
+	fi
+	return 1
+}
+
+# fail records that setting up path $1 failed
+fail() {
+	log "error: $1: failed to set up"
+	failed=1
+}
+
+# has_commit checks if the repository at $1 contains commit $2
+has_commit() {
+	git -C "$1" cat-file -e "$2^{commit}" 2>/dev/null
+}
+
+# ensure_commit fetches commit $2 into the repository at $1 when it is
+# missing, trying branch $3 first when it is not empty
+ensure_commit() {
+	has_commit "$1" "$2" && return 0
+	if [ -n "$3" ]; then
+		git -C "$1" fetch -- origin "+refs/heads/$3:refs/remotes/origin/$3" && has_commit "$1" "$2" && return 0
+	fi
+	git -C "$1" fetch -- origin "$2" && has_commit "$1" "$2" && return 0
+	log "error: commit $2 not found on remote"
+	return 1
+}
+
+# checkout_branch checks out branch $2 in the repository at $1, creating it
+# when it does not exist
+checkout_branch() {
+	git -C "$1" checkout -q "$2" 2>/dev/null || git -C "$1" checkout -q -b "$2"
+}
+
+# main_checkout checks that the main checkout $1 of a worktree exists
+main_checkout() {
+	[ -d "$1" ] && return 0
+	log "error: main checkout $1 does not exist"
+	return 1
+}
+
+# pull_lfs fetches LFS objects for the checkout at $1, warning instead of
+# failing when git-lfs is not installed
+pull_lfs() {
+	if ! command -v git-lfs >/dev/null 2>&1; then
+		log "warning: $1 uses Git LFS but git-lfs is not installed, files are left as LFS pointers"
+		return 0
+	fi
+	git -C "$1" lfs install --local && git -C "$1" lfs pull
+}
+`
+
+// ExportShell converts state to a POSIX shell script that sets up the
+// repositories with git the same way Apply does, for machines without gate.
+// Repositories are set up in the same order, with the same clone options,
+// fetches of missing commits, checkouts and worktrees. Repositories that
+// Apply would fail to set up, such as main checkouts without a remote URL,
+// are left out, and a warning describing each is returned.
+func ExportShell(state *State, opts ExportOptions) ([]byte, []string, error) {
+	var buf bytes.Buffer
+	buf.WriteString(scriptHeader)
+	var warnings []string
+	for _, repo := range applyOrder(state.Repositories) {
+		// The commit is passed to git in several steps, not only fetches
+		if repo.Commit != "" {
+			if err := checkCommit(repo.Commit); err != nil {
+				warnings = append(warnings, fmt.Sprintf("%s: %v, skipped", repo.Path, err))
+				continue
+			}
+		}
+		var steps []string
+		if repo.IsWorktree {
+			if repo.MainCheckoutPath == nil {
+				warnings = append(warnings, fmt.Sprintf("%s: no main checkout path for worktree, skipped", repo.Path))
+				continue
+			}
+			steps = worktreeSteps(repo)
+		} else {
+			if repo.RemoteURL == "" {
+				warnings = append(warnings, fmt.Sprintf("%s: no remote URL, skipped", repo.Path))
+				continue
+			}
+			var dropped []string
+			repo.Config, dropped = filterConfig(repo, ConfigFilter{}.orDefault())
+			warnings = append(warnings, dropped...)
+			steps = mainCheckoutSteps(repo)
+		}
+
+		path := shellQuote(repo.Path)
+		// A newline in the comment would end it and run the rest of the path
+		comment := strings.NewReplacer("\n", " ", "\r", " ").Replace(repo.Path)
+		fmt.Fprintf(&buf, "\n# %s\nif ! skip_existing %s; then\n", comment, path)
+		for _, step := range steps {
+			fmt.Fprintf(&buf, "\t%s &&\n", step)
+		}
+		// The last step ends the chain instead
+		buf.Truncate(buf.Len() - len(" &&\n"))
+		fmt.Fprintf(&buf, " ||\n\t\tfail %s\nfi\n", path)
+	}
+	buf.WriteString("\nexit \"$failed\"\n")
+	return buf.Bytes(), warnings, nil
+}
+
+// mainCheckoutSteps returns the commands that clone and check out a main
+// repository, following applyMainCheckout
+func mainCheckoutSteps(repo Repository) []string {
+	steps := []string{shellJoin("log", fmt.Sprintf("cloning %s from %s", repo.Path, repo.RemoteURL))}
+	if parent := filepath.Dir(repo.Path); parent != "." {
+		steps = append(steps, shellJoin("mkdir", "-p", parent))
+	}
+	cloneOpts := CloneOptions{
+		NoCheckout: repo.SparseCheckout != nil,
+		Depth:      repo.Depth,
+		Filter:     repo.Filter,
+		Bare:       repo.Bare,
+		Mirror:     repo.Mirror,
+	}
+	steps = append(steps, shellJoin(append([]string{"git"}, cloneArgs(repo.RemoteURL, repo.Path, cloneOpts)...)...))
+	git := func(args ...string) string {
+		return shellJoin(append([]string{"git", "-C", repo.Path}, args...)...)
+	}
+
+	if repo.Bare {
+		if repo.Branch != "" && !repo.isDetached() {
+			steps = append(steps, git("symbolic-ref", "HEAD", "refs/heads/"+repo.Branch))
+		}
+		return append(steps, configSteps(repo)...)
+	}
+
+	if repo.SparseCheckout != nil {
+		steps = append(steps, git(sparseCheckoutArgs(repo.SparseCheckout)...))
+	}
+	steps = append(steps, ensureCommitSteps(repo.Path, repo)...)
+	if repo.isDetached() {
+		commit := repo.Commit
+		if commit == "" {
+			commit = "HEAD"
+		}
+		steps = append(steps, git("update-ref", "--no-deref", "HEAD", commit), git("reset", "-q", "--hard", "HEAD"))
+	} else {
+		if repo.Branch != "" {
+			steps = append(steps, shellJoin("checkout_branch", repo.Path, repo.Branch))
+		}
+		if repo.Commit != "" {
+			steps = append(steps, git("reset", "-q", "--hard", repo.Commit))
+		}
+	}
+	if repo.LFS {
+		steps = append(steps, shellJoin("pull_lfs", repo.Path))
+	}
+	return append(steps, configSteps(repo)...)
+}
+
+// configSteps returns the commands that set the config of repo, following
+// applyConfig. They come last, so that config such as core.hooksPath cannot
+// run hooks from the repository while the script checks out files.
+func configSteps(repo Repository) []string {
+	var steps []string
+	for _, args := range configArgs(repo.Config) {
+		steps = append(steps, shellJoin(append([]string{"git", "-C", repo.Path}, args...)...))
+	}
+	return steps
+}
+
+// worktreeSteps returns the commands that add a worktree to its main
+// checkout, following applyWorktree
+func worktreeSteps(repo Repository) []string {
+	mainPath := worktreeMainPath(repo.Path, repo)
+	steps := []string{shellJoin("main_checkout", mainPath)}
+	steps = append(steps, ensureCommitSteps(mainPath, repo)...)
+	steps = append(steps, shellJoin("log", fmt.Sprintf("adding worktree %s from %s", repo.Path, mainPath)))
+
+	// git runs worktree commands in the main checkout, so the worktree path
+	// must be absolute
+	worktreePath := shellQuote(repo.Path)
+	if !filepath.IsAbs(repo.Path) {
+		worktreePath = `"$root"/` + worktreePath
+	}
+	add := shellJoin("git", "-C", mainPath, "worktree", "add")
+	if repo.SparseCheckout != nil {
+		add += " --no-checkout"
+	}
+	git := func(args ...string) string {
+		return shellJoin(append([]string{"git", "-C", repo.Path}, args...)...)
+	}
+
+	if repo.isDetached() {
+		detach := add + " --detach " + worktreePath
+		if repo.Commit != "" {
+			detach += " " + shellQuote(repo.Commit)
+		}
+		steps = append(steps, detach)
+		if repo.SparseCheckout != nil {
+			steps = append(steps, git(sparseCheckoutArgs(repo.SparseCheckout)...), git("reset", "-q", "--hard", "HEAD"))
+		}
+	} else {
+		// Check out the branch, creating it when it does not exist
+		steps = append(steps, fmt.Sprintf("{ %s %s %s 2>/dev/null || %s -b %s %s; }",
+			add, worktreePath, shellQuote(repo.Branch), add, shellQuote(repo.Branch), worktreePath))
+		commit := repo.Commit
+		if repo.SparseCheckout != nil {
+			steps = append(steps, git(sparseCheckoutArgs(repo.SparseCheckout)...))
+			// Nothing is checked out yet, so a reset is always needed
+			if commit == "" {
+				commit = "HEAD"
+			}
+		}
+		if commit != "" {
+			steps = append(steps, git("reset", "-q", "--hard", commit))
+		}
+	}
+	if repo.LFS {
+		steps = append(steps, shellJoin("pull_lfs", repo.Path))
+	}
+	return steps
+}
+
+// ensureCommitSteps returns the command that fetches the commit of repo into
+// the repository at path when it is missing, following ensureCommit
+func ensureCommitSteps(path string, repo Repository) []string {
+	if repo.Commit == "" {
+		return nil
+	}
+	branch := ""
+	if !repo.isDetached() {
+		branch = repo.Branch
+	}
+	return []string{shellJoin("ensure_commit", path, repo.Commit, branch)}
+}
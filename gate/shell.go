@@ -0,0 +1,101 @@
+package gate
+
+import (
+	"fmt"
+	"strings"
+)
+
+// shellQuote quotes s as a single word for a POSIX shell, leaving words that
+// contain only safe characters unquoted for readability
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789@%+=:,./_-") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// shellJoin quotes each of args and joins them into a shell command
+func shellJoin(args ...string) string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		quoted[i] = shellQuote(a)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// shellSplit splits a simple shell command line into commands separated by
+// &&, each of which is split into words with quotes removed. Other
+// operators, substitutions and variables are reported as errors, because
+// what they do cannot be known without running a shell.
+func shellSplit(line string) ([][]string, error) {
+	var commands [][]string
+	var words []string
+	var word strings.Builder
+	inWord := false
+	endWord := func() {
+		if inWord {
+			words = append(words, word.String())
+			word.Reset()
+			inWord = false
+		}
+	}
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			endWord()
+		case c == '\'':
+			end := strings.IndexByte(line[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated single quote")
+			}
+			word.WriteString(line[i+1 : i+1+end])
+			inWord = true
+			i += end + 1
+		case c == '"':
+			inWord = true
+			for i++; ; i++ {
+				if i >= len(line) {
+					return nil, fmt.Errorf("unterminated double quote")
+				}
+				c := line[i]
+				if c == '"' {
+					break
+				}
+				if c == '$' || c == '`' {
+					return nil, fmt.Errorf("unsupported %q in double quotes", c)
+				}
+				if c == '\\' && i+1 < len(line) && strings.IndexByte("\"\\$`", line[i+1]) >= 0 {
+					i++
+					c = line[i]
+				}
+				word.WriteByte(c)
+			}
+		case c == '\\':
+			if i+1 < len(line) {
+				i++
+				word.WriteByte(line[i])
+				inWord = true
+			}
+		case c == '&' && i+1 < len(line) && line[i+1] == '&':
+			endWord()
+			if len(words) == 0 {
+				return nil, fmt.Errorf("empty command before &&")
+			}
+			commands = append(commands, words)
+			words = nil
+			i++
+		case strings.IndexByte(";|&<>()$`", c) >= 0:
+			return nil, fmt.Errorf("unsupported %q", c)
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	endWord()
+	if len(words) == 0 {
+		return nil, fmt.Errorf("empty command")
+	}
+	return append(commands, words), nil
+}
@@ -0,0 +1,56 @@
+package gate
+
+// Repository represents a single git repository or worktree
+type Repository struct {
+	Path             string            `json:"path" yaml:"path" toml:"path"`
+	RemoteURL        string            `json:"remote_url,omitempty" yaml:"remote_url,omitempty" toml:"remote_url,omitempty"`
+	Branch           string            `json:"branch" yaml:"branch" toml:"branch"`
+	Commit           string            `json:"commit" yaml:"commit" toml:"commit"`
+	Detached         bool              `json:"detached,omitempty" yaml:"detached,omitempty" toml:"detached,omitempty"`
+	IsWorktree       bool              `json:"is_worktree,omitempty" yaml:"is_worktree,omitempty" toml:"is_worktree,omitempty"`
+	MainCheckoutPath *string           `json:"main_checkout_path,omitempty" yaml:"main_checkout_path,omitempty" toml:"main_checkout_path,omitempty"`
+	Depth            int               `json:"depth,omitempty" yaml:"depth,omitempty" toml:"depth,omitzero"`
+	Filter           string            `json:"filter,omitempty" yaml:"filter,omitempty" toml:"filter,omitempty"`
+	Config           map[string]string `json:"config,omitempty" yaml:"config,omitempty" toml:"config,omitempty"`
+	SparseCheckout   *SparseCheckout   `json:"sparse_checkout,omitempty" yaml:"sparse_checkout,omitempty" toml:"sparse_checkout,omitempty"`
+	LFS              bool              `json:"lfs,omitempty" yaml:"lfs,omitempty" toml:"lfs,omitempty"`
+	Bare             bool              `json:"bare,omitempty" yaml:"bare,omitempty" toml:"bare,omitempty"`
+	Mirror           bool              `json:"mirror,omitempty" yaml:"mirror,omitempty" toml:"mirror,omitempty"`
+}
+
+// SparseCheckout represents the sparse-checkout mode and patterns of a checkout
+type SparseCheckout struct {
+	Cone     bool     `json:"cone" yaml:"cone" toml:"cone"`
+	Patterns []string `json:"patterns" yaml:"patterns" toml:"patterns"`
+}
+
+// isDetached checks if the repository has a detached HEAD. State written
+// before detached HEADs were recorded explicitly only has the "HEAD" branch.
+func (r Repository) isDetached() bool {
+	return r.Detached || r.Branch == "HEAD"
+}
+
+// headLabel describes what HEAD points at for display
+func (r Repository) headLabel() string {
+	if r.isDetached() {
+		return "detached HEAD"
+	}
+	if r.Branch == "" {
+		return "default branch"
+	}
+	return r.Branch
+}
+
+// checkoutLabel describes what is checked out for display. State imported
+// from other tools may name a branch without pinning a commit.
+func (r Repository) checkoutLabel() string {
+	if r.Commit == "" {
+		return r.headLabel()
+	}
+	return r.headLabel() + " at " + shortCommit(r.Commit)
+}
+
+// State represents the complete state of all repositories
+type State struct {
+	Repositories []Repository `json:"repositories" yaml:"repositories" toml:"repositories"`
+}
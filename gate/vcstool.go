@@ -0,0 +1,99 @@
+package gate
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// vcstoolFile is a vcstool .repos file
+type vcstoolFile struct {
+	Repositories map[string]vcstoolRepo `yaml:"repositories"`
+}
+
+// vcstoolRepo is a single entry of a vcstool .repos file
+type vcstoolRepo struct {
+	Type    string `yaml:"type"`
+	URL     string `yaml:"url"`
+	Version string `yaml:"version,omitempty"`
+}
+
+// commitPattern matches a full SHA-1 or SHA-256 commit hash
+var commitPattern = regexp.MustCompile(`^([0-9a-f]{40}|[0-9a-f]{64})$`)
+
+// ImportVcstool converts a vcstool .repos file to state. A version that is a
+// full commit hash is checked out as a detached HEAD, and any other version
+// is checked out by name as a branch or tag. Entries that are not git
+// repositories are skipped, and a warning describing each is returned.
+func ImportVcstool(data []byte) (*State, []string, error) {
+	var file vcstoolFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse vcstool file: %w", err)
+	}
+
+	paths := make([]string, 0, len(file.Repositories))
+	for p := range file.Repositories {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	state := &State{Repositories: make([]Repository, 0, len(paths))}
+	var warnings []string
+	for _, p := range paths {
+		entry := file.Repositories[p]
+		if entry.Type != "git" {
+			warnings = append(warnings, fmt.Sprintf("%s: %s repositories are not supported, skipped", p, entry.Type))
+			continue
+		}
+		repo := Repository{Path: p, RemoteURL: entry.URL}
+		if commitPattern.MatchString(entry.Version) {
+			repo.Branch = "HEAD"
+			repo.Commit = entry.Version
+			repo.Detached = true
+		} else {
+			repo.Branch = entry.Version
+		}
+		state.Repositories = append(state.Repositories, repo)
+	}
+	return state, warnings, nil
+}
+
+// ExportVcstool converts state to a vcstool .repos file. Each repository's
+// version is its branch, or its commit when detached or when opts.Exact is
+// set. Details that vcstool cannot represent, such as worktrees, are left
+// out, and a warning describing each is returned.
+func ExportVcstool(state *State, opts ExportOptions) ([]byte, []string, error) {
+	file := vcstoolFile{Repositories: map[string]vcstoolRepo{}}
+	var warnings []string
+	for _, repo := range state.Repositories {
+		if repo.IsWorktree {
+			warnings = append(warnings, fmt.Sprintf("%s: worktrees are not supported by vcstool, skipped", repo.Path))
+			continue
+		}
+		if repo.RemoteURL == "" {
+			warnings = append(warnings, fmt.Sprintf("%s: no remote URL, skipped", repo.Path))
+			continue
+		}
+		warnings = append(warnings, lossyFields(repo, "vcstool")...)
+
+		version := repo.Branch
+		if repo.isDetached() || opts.Exact {
+			version = repo.Commit
+		}
+		file.Repositories[repo.Path] = vcstoolRepo{Type: "git", URL: repo.RemoteURL, Version: version}
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(file); err != nil {
+		return nil, nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, nil, err
+	}
+	return buf.Bytes(), warnings, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// Log formats for --log-format
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// newLogger returns a logger writing records at level and above to w in
+// format
+func newLogger(level, format string, w io.Writer) (*slog.Logger, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q: must be debug, info, warn or error", level)
+	}
+
+	switch format {
+	case logFormatText:
+		return slog.New(&textHandler{w: w, level: l, mu: &sync.Mutex{}}), nil
+	case logFormatJSON:
+		return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{
+			Level: l,
+			ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				// Messages are indented for people reading text output
+				if len(groups) == 0 && a.Key == slog.MessageKey {
+					a.Value = slog.StringValue(strings.TrimSpace(a.Value.String()))
+				}
+				return a
+			},
+		})), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q: must be %s or %s", format, logFormatText, logFormatJSON)
+	}
+}
+
+// textHandler writes each record's message on its own line for people to
+// read, prefixing warnings and errors. Attributes are left out because the
+// messages already describe them.
+type textHandler struct {
+	w     io.Writer
+	level slog.Level
+	mu    *sync.Mutex
+}
+
+func (h *textHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *textHandler) Handle(ctx context.Context, r slog.Record) error {
+	var prefix string
+	switch {
+	case r.Level >= slog.LevelError:
+		prefix = "error: "
+	case r.Level >= slog.LevelWarn:
+		prefix = "warning: "
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, prefix+r.Message+"\n")
+	return err
+}
+
+func (h *textHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h
+}
+
+func (h *textHandler) WithGroup(name string) slog.Handler {
+	return h
+}
@@ -1,11 +1,15 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
+	"strings"
+	"time"
 
+	"github.com/leighmcculloch/gate/gate"
 	"github.com/spf13/cobra"
 )
 
@@ -14,7 +18,14 @@ func main() {
 }
 
 func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
-	var verbose bool
+	return runWithBackend(gate.ExecBackend{}, args, stdin, stdout, stderr)
+}
+
+// runWithBackend runs the CLI using backend for all git operations
+func runWithBackend(backend gate.GitBackend, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	opts := gate.Options{
+		Git: backend,
+	}
 
 	rootCmd := &cobra.Command{
 		Use:   "gate",
@@ -25,61 +36,430 @@ func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
 		},
 	}
 
-	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "verbose output")
+	var verbose bool
+	var logLevel, logFormat, progressMode string
+	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "verbose output (same as --log-level debug)")
+	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "info", "minimum level of messages to log: debug, info, warn or error")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", logFormatText, "format of log messages: text or json")
+	rootCmd.PersistentFlags().StringVar(&progressMode, "progress", progressAuto, "progress display: auto (bar on a terminal, plain otherwise), bar, plain or none")
+
+	// setupOutput sets up logging and progress for a command, and returns a
+	// function that removes the progress display once the command finishes
+	setupOutput := func(cmd *cobra.Command) (func(), error) {
+		if verbose && !cmd.Flags().Changed("log-level") {
+			logLevel = "debug"
+		}
+		p, err := newProgressReporter(progressMode, stderr)
+		if err != nil {
+			return nil, err
+		}
+		w := stderr
+		if p != nil {
+			w = p
+		}
+		opts.Logger, err = newLogger(logLevel, logFormat, w)
+		if err != nil {
+			return nil, err
+		}
+		if p == nil {
+			return func() {}, nil
+		}
+		p.logger = opts.Logger
+		opts.Progress = p.event
+		return p.finish, nil
+	}
+
+	var rewriteRules []string
+	var rewriteFile string
+	// addRewriteFlags adds the flags that set URL rewrite rules to cmd
+	addRewriteFlags := func(cmd *cobra.Command, when string) {
+		cmd.Flags().StringArrayVar(&rewriteRules, "rewrite", nil, "rewrite remote URLs starting with old to start with new "+when+", as old=new (repeatable)")
+		cmd.Flags().StringVar(&rewriteFile, "rewrite-file", "", "file of old=new remote URL rewrite rules, one per line")
+		cmd.Flags().BoolVar(&opts.GitInsteadOf, "git-insteadof", false, "also rewrite remote URLs with the url.<base>.insteadOf rules in git config")
+	}
 
+	var configExclude []string
+	// addConfigFlags adds the flags that select repository-local config keys
+	// to cmd
+	addConfigFlags := func(cmd *cobra.Command, verb string) {
+		cmd.Flags().StringSliceVar(&opts.Config.Include, "config-include", gate.DefaultConfigInclude, "repository-local config keys to "+verb+" (* matches any characters)")
+		cmd.Flags().StringSliceVar(&configExclude, "config-exclude", nil, "repository-local config keys never to "+verb+", in addition to keys that commonly hold credentials (empty to "+verb+" those too)")
+	}
+	// readConfigExclude sets the config keys excluded by cmd's flags, which
+	// add to DefaultConfigExclude unless given as empty
+	readConfigExclude := func(cmd *cobra.Command) {
+		switch {
+		case !cmd.Flags().Changed("config-exclude"):
+			opts.Config.Exclude = nil
+		case len(configExclude) == 0:
+			opts.Config.Exclude = []string{}
+		default:
+			opts.Config.Exclude = append(gate.DefaultConfigExclude[:len(gate.DefaultConfigExclude):len(gate.DefaultConfigExclude)], configExclude...)
+		}
+	}
+
+	var identityFiles []string
+	var passphraseFile string
+	// addDecryptFlags adds the flags that give the keys to decrypt encrypted
+	// state with to cmd
+	addDecryptFlags := func(cmd *cobra.Command) {
+		cmd.Flags().StringArrayVarP(&identityFiles, "identity", "i", nil, "identity file from gate keygen to decrypt encrypted state with (repeatable)")
+		cmd.Flags().StringVar(&passphraseFile, "passphrase-file", "", "file whose first line is the passphrase to decrypt encrypted state with (defaults to $"+passphraseEnv+")")
+	}
+
+	var recipients []string
+	var recipientsFile string
+	var encryptPassphrase bool
+	// addEncryptFlags adds the flags that choose who output is encrypted
+	// for to cmd
+	addEncryptFlags := func(cmd *cobra.Command) {
+		cmd.Flags().StringArrayVarP(&recipients, "recipient", "r", nil, "encrypt the output to this recipient public key from gate keygen (repeatable)")
+		cmd.Flags().StringVar(&recipientsFile, "recipients-file", "", "file of recipient public keys to encrypt the output to, one per line")
+		cmd.Flags().BoolVar(&encryptPassphrase, "passphrase", false, "encrypt the output with the passphrase in --passphrase-file or $"+passphraseEnv)
+	}
+
+	var formatName, output string
 	captureCmd := &cobra.Command{
 		Use:   "capture",
-		Short: "Capture git repository state to JSON",
-		Long:  "Scan directories above, below, and at the current location for git repositories and output their state as JSON.",
+		Short: "Capture git repository state to JSON, YAML or TOML",
+		Long:  "Scan directories above, below, and at the current location for git repositories and output their state as JSON, YAML or TOML.",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			state, err := capture(stderr, verbose)
+			cmd.SilenceUsage = true
+			finish, err := setupOutput(cmd)
+			if err != nil {
+				return err
+			}
+			defer finish()
+
+			format, err := gate.ParseFormat(formatName)
+			if err != nil {
+				return err
+			}
+			if f, ok := formatFromExt(output); ok && !cmd.Flags().Changed("format") {
+				format = f
+			}
+			opts.Rewrites, err = readRewrites(rewriteRules, rewriteFile)
+			if err != nil {
+				return err
+			}
+			readConfigExclude(cmd)
+			encrypt, err := readEncryptOptions(recipients, recipientsFile, encryptPassphrase, passphraseFile)
+			if err != nil {
+				return err
+			}
+
+			state, err := gate.Capture(cmd.Context(), opts)
 			if err != nil {
 				return err
 			}
 
-			if verbose {
-				fmt.Fprintf(stderr, "writing JSON output\n")
+			opts.Logger.Debug(fmt.Sprintf("writing %s output", strings.ToUpper(string(format))))
+			data, err := gate.Marshal(state, format)
+			if err != nil {
+				return fmt.Errorf("failed to encode state: %w", err)
 			}
-			encoder := json.NewEncoder(stdout)
-			encoder.SetIndent("", "  ")
-			return encoder.Encode(state)
+			if encrypt != nil {
+				opts.Logger.Debug(fmt.Sprintf("encrypting output for %d recipients", len(encrypt.Recipients)))
+				data, err = gate.Encrypt(data, *encrypt)
+				if err != nil {
+					return fmt.Errorf("failed to encrypt state: %w", err)
+				}
+			}
+			return writeOutput(output, data, stdout)
 		},
 	}
 
+	captureCmd.Flags().StringVar(&formatName, "format", string(gate.FormatJSON), "output format: json, yaml or toml (defaults to the --output extension, or json)")
+	captureCmd.Flags().StringVarP(&output, "output", "o", stdio, "file to write state to, replacing it only once capture succeeds (- for stdout)")
+	addConfigFlags(captureCmd, "capture")
+	captureCmd.Flags().BoolVar(&opts.KeepCredentials, "keep-credentials", false, "record credentials in remote URLs and config values instead of removing them")
+	addRewriteFlags(captureCmd, "before recording them")
+	addEncryptFlags(captureCmd)
+	captureCmd.Flags().StringVar(&passphraseFile, "passphrase-file", "", "file whose first line is the passphrase for --passphrase")
+
+	var files []string
+	var layoutName string
 	applyCmd := &cobra.Command{
 		Use:   "apply",
-		Short: "Apply git repository state from JSON",
-		Long:  "Read JSON from stdin and set up repositories and worktrees accordingly.",
+		Short: "Apply git repository state from JSON, YAML or TOML",
+		Long:  "Read state from stdin or files in JSON, YAML or TOML, detecting which, and set up repositories and worktrees accordingly.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			finish, err := setupOutput(cmd)
+			if err != nil {
+				return err
+			}
+			defer finish()
+
+			opts.Layout, err = gate.ParseLayout(layoutName)
+			if err != nil {
+				return err
+			}
+			opts.Rewrites, err = readRewrites(rewriteRules, rewriteFile)
+			if err != nil {
+				return err
+			}
+			readConfigExclude(cmd)
+			keys, err := readDecryptOptions(identityFiles, passphraseFile)
+			if err != nil {
+				return err
+			}
+			state, err := readStateFiles(files, stdin, keys, opts.Logger)
+			if err != nil {
+				return err
+			}
+
+			opts.Logger.Debug(fmt.Sprintf("found %d repositories to apply", len(state.Repositories)))
+			_, err = gate.Apply(cmd.Context(), state, opts)
+			return err
+		},
+	}
+
+	applyCmd.Flags().StringArrayVarP(&files, "file", "f", []string{stdio}, "file to read state from, merged when repeated (- for stdin)")
+	applyCmd.Flags().IntVar(&opts.Depth, "depth", 0, "shallow clone with history truncated to this many commits, overriding captured depth")
+	applyCmd.Flags().StringVar(&opts.Filter, "filter", "", "partial clone filter (e.g. blob:none), overriding captured filter")
+	applyCmd.Flags().IntVar(&opts.Retries, "retries", 2, "times to retry clones and fetches that fail with a transient network error")
+	applyCmd.Flags().DurationVar(&opts.RetryDelay, "retry-delay", 2*time.Second, "delay before the first retry, doubling for each retry after it")
+	applyCmd.Flags().StringVar(&opts.Journal, "journal", ".gate-journal", "file to record apply progress in, for --resume, removed once every repository is set up (empty to disable)")
+	applyCmd.Flags().BoolVar(&opts.Resume, "resume", false, "resume an interrupted apply: skip repositories it completed, redo half-created clones and finish the rest in place")
+	applyCmd.Flags().StringArrayVar(&opts.FetchRefspecs, "fetch-refspec", nil, "extra refspec to fetch from origin when a captured commit is missing (repeatable)")
+	applyCmd.Flags().StringVar(&layoutName, "layout", string(gate.LayoutCaptured), "where to place repositories: captured (the captured paths) or ghq (host/owner/name from the remote URL)")
+	applyCmd.Flags().StringVar(&opts.LayoutRoot, "layout-root", ".", "directory that --layout ghq places repositories under")
+	addConfigFlags(applyCmd, "apply")
+	addRewriteFlags(applyCmd, "before cloning")
+	addDecryptFlags(applyCmd)
+
+	var from, importFile string
+	importCmd := &cobra.Command{
+		Use:   "import",
+		Short: "Convert another tool's file to git repository state",
+		Long:  "Read a file written for another tool and output the repositories it describes as state that apply can use.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			importer, ok := importers[from]
+			if !ok {
+				return fmt.Errorf("unknown --from %q: must be one of %s", from, converterNames(importers))
+			}
+			format, err := gate.ParseFormat(formatName)
+			if err != nil {
+				return err
+			}
+			if f, ok := formatFromExt(output); ok && !cmd.Flags().Changed("format") {
+				format = f
+			}
+			finish, err := setupOutput(cmd)
+			if err != nil {
+				return err
+			}
+			defer finish()
+
+			data, err := readInput(importFile, stdin)
+			if err != nil {
+				return err
+			}
+			state, warnings, err := importer(data)
+			if err != nil {
+				return err
+			}
+			for _, w := range warnings {
+				opts.Logger.Warn(w, "event", "lossy")
+			}
+			opts.Logger.Debug(fmt.Sprintf("imported %d repositories from %s", len(state.Repositories), from))
+
+			out, err := gate.Marshal(state, format)
+			if err != nil {
+				return fmt.Errorf("failed to encode state: %w", err)
+			}
+			return writeOutput(output, out, stdout)
+		},
+	}
+
+	importCmd.Flags().StringVar(&from, "from", "", "format to convert from: "+converterNames(importers))
+	importCmd.Flags().StringVarP(&importFile, "file", "f", stdio, "file to convert (- for stdin)")
+	importCmd.Flags().StringVar(&formatName, "format", string(gate.FormatJSON), "output format: json, yaml or toml (defaults to the --output extension, or json)")
+	importCmd.Flags().StringVarP(&output, "output", "o", stdio, "file to write state to (- for stdout)")
+	importCmd.MarkFlagRequired("from")
+
+	var to string
+	var exportOpts gate.ExportOptions
+	exportCmd := &cobra.Command{
+		Use:   "export",
+		Short: "Convert git repository state to another tool's file",
+		Long:  "Read state from stdin or files and output it in a format another tool can use, warning about details the format cannot represent.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			exporter, ok := exporters[to]
+			if !ok {
+				return fmt.Errorf("unknown --to %q: must be one of %s", to, converterNames(exporters))
+			}
+			finish, err := setupOutput(cmd)
+			if err != nil {
+				return err
+			}
+			defer finish()
+
+			keys, err := readDecryptOptions(identityFiles, passphraseFile)
+			if err != nil {
+				return err
+			}
+			state, err := readStateFiles(files, stdin, keys, opts.Logger)
+			if err != nil {
+				return err
+			}
+			data, warnings, err := exporter(state, exportOpts)
+			if err != nil {
+				return err
+			}
+			for _, w := range warnings {
+				opts.Logger.Warn(w, "event", "lossy")
+			}
+			return writeOutput(output, data, stdout)
+		},
+	}
+
+	exportCmd.Flags().StringVar(&to, "to", "", "format to convert to: "+converterNames(exporters))
+	exportCmd.Flags().StringArrayVarP(&files, "file", "f", []string{stdio}, "file to read state from, merged when repeated (- for stdin)")
+	exportCmd.Flags().StringVarP(&output, "output", "o", stdio, "file to write to, replacing it only once the export succeeds (- for stdout)")
+	exportCmd.Flags().BoolVar(&exportOpts.Exact, "exact", false, "pin repositories to their commit instead of their branch")
+	addDecryptFlags(exportCmd)
+	exportCmd.MarkFlagRequired("to")
+
+	var scrubFile, scrubFormat string
+	scrubCmd := &cobra.Command{
+		Use:   "scrub",
+		Short: "Remove credentials from git repository state",
+		Long:  "Read state from stdin or a file and output it without the credentials in remote URLs and config values, such as user info and token query parameters, warning about each one removed.",
+		Args:  cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if verbose {
-				fmt.Fprintf(stderr, "reading JSON from stdin\n")
+			cmd.SilenceUsage = true
+			finish, err := setupOutput(cmd)
+			if err != nil {
+				return err
 			}
-			data, err := io.ReadAll(stdin)
+			defer finish()
+
+			keys, err := readDecryptOptions(identityFiles, passphraseFile)
 			if err != nil {
-				return fmt.Errorf("failed to read stdin: %w", err)
+				return err
+			}
+			encrypt, err := readEncryptOptions(recipients, recipientsFile, encryptPassphrase, passphraseFile)
+			if err != nil {
+				return err
+			}
+			data, err := readInput(scrubFile, stdin)
+			if err != nil {
+				return err
+			}
+			name := scrubFile
+			if name == stdio {
+				name = "stdin"
+			}
+			data, encrypted, err := decryptInput(data, name, keys, opts.Logger)
+			if err != nil {
+				return err
+			}
+			if encrypted && encrypt == nil {
+				return fmt.Errorf("%s is encrypted, so the output must be too: use --recipient, --recipients-file or --passphrase", name)
+			}
+			var state gate.State
+			format, err := gate.Unmarshal(data, &state)
+			if err != nil {
+				return err
+			}
+			// Output keeps the format of the input unless told otherwise
+			if f, ok := formatFromExt(output); ok {
+				format = f
+			}
+			if cmd.Flags().Changed("format") {
+				format, err = gate.ParseFormat(scrubFormat)
+				if err != nil {
+					return err
+				}
 			}
 
-			if verbose {
-				fmt.Fprintf(stderr, "parsing JSON (%d bytes)\n", len(data))
+			warnings := gate.Scrub(&state)
+			for _, w := range warnings {
+				opts.Logger.Warn(w, "event", "credentials_removed")
 			}
-			var state State
-			if err := json.Unmarshal(data, &state); err != nil {
-				return fmt.Errorf("failed to parse JSON: %w", err)
+			opts.Logger.Debug(fmt.Sprintf("removed %d credentials", len(warnings)))
+
+			out, err := gate.Marshal(&state, format)
+			if err != nil {
+				return fmt.Errorf("failed to encode state: %w", err)
 			}
+			if encrypt != nil {
+				out, err = gate.Encrypt(out, *encrypt)
+				if err != nil {
+					return fmt.Errorf("failed to encrypt state: %w", err)
+				}
+			}
+			return writeOutput(output, out, stdout)
+		},
+	}
+
+	scrubCmd.Flags().StringVarP(&scrubFile, "file", "f", stdio, "file to read state from (- for stdin)")
+	scrubCmd.Flags().StringVar(&scrubFormat, "format", "", "output format: json, yaml or toml (defaults to the --output extension, or the input format)")
+	scrubCmd.Flags().StringVarP(&output, "output", "o", stdio, "file to write state to, which may be the input file (- for stdout)")
+	addDecryptFlags(scrubCmd)
+	addEncryptFlags(scrubCmd)
 
-			if verbose {
-				fmt.Fprintf(stderr, "found %d repositories to apply\n", len(state.Repositories))
+	var keyFile string
+	keygenCmd := &cobra.Command{
+		Use:   "keygen",
+		Short: "Generate a key pair for encrypting state",
+		Long:  "Generate an identity, the private key that decrypts state, and log its recipient, the public key that capture --recipient encrypts state to.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			finish, err := setupOutput(cmd)
+			if err != nil {
+				return err
+			}
+			defer finish()
+
+			id, err := gate.GenerateIdentity()
+			if err != nil {
+				return err
+			}
+			data := fmt.Appendf(nil, "# created: %s\n# recipient: %s\n%s\n", time.Now().Format(time.RFC3339), id.Recipient(), id)
+			if keyFile == stdio {
+				if _, err := stdout.Write(data); err != nil {
+					return err
+				}
+			} else {
+				// Replacing an identity would lose access to state encrypted
+				// to it
+				if _, err := os.Stat(keyFile); err == nil {
+					return fmt.Errorf("%s already exists", keyFile)
+				}
+				if err := writeFileAtomic(keyFile, data, 0600); err != nil {
+					return fmt.Errorf("failed to write %s: %w", keyFile, err)
+				}
 			}
-			return apply(&state, stderr, verbose)
+			opts.Logger.Info(fmt.Sprintf("recipient: %s", id.Recipient()), "recipient", id.Recipient().String())
+			return nil
 		},
 	}
 
-	rootCmd.AddCommand(captureCmd, applyCmd)
+	keygenCmd.Flags().StringVarP(&keyFile, "output", "o", stdio, "file to write the identity to, which must not exist (- for stdout)")
+
+	rootCmd.AddCommand(captureCmd, applyCmd, importCmd, exportCmd, scrubCmd, keygenCmd)
 	rootCmd.SetArgs(args[1:])
 	rootCmd.SetOut(stdout)
 	rootCmd.SetErr(stderr)
 
-	if err := rootCmd.Execute(); err != nil {
+	// Interrupting stops new work and lets in-progress git commands clean up.
+	// A second interrupt terminates immediately.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	if err := rootCmd.ExecuteContext(ctx); err != nil {
 		return 1
 	}
 	return 0
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/leighmcculloch/gate/gate"
+)
+
+// Progress display modes for --progress
+const (
+	progressAuto  = "auto"
+	progressBar   = "bar"
+	progressPlain = "plain"
+	progressNone  = "none"
+)
+
+// progressBarWidth is the number of characters inside the apply progress bar
+const progressBarWidth = 20
+
+// progressScanInterval limits how often the status line is redrawn while
+// capture scans directories, which happens far faster than a terminal can
+// usefully show
+const progressScanInterval = 100 * time.Millisecond
+
+// spinnerFrames are drawn in turn while capture scans directories
+var spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// progressReporter displays progress events from capture and apply. In bar
+// mode it keeps a status line at the bottom of the terminal, with a spinner
+// during capture and a bar during apply, and log messages are written above
+// it. In plain mode it writes git's progress as occasional lines between log
+// messages, suitable for logs and CI output. Log messages are written through
+// the reporter so that they do not collide with the status line.
+type progressReporter struct {
+	mu  sync.Mutex
+	w   io.Writer
+	bar bool
+	// width is the number of columns the status line is truncated to
+	width int
+
+	// status is the status line currently drawn, empty when none is
+	status   string
+	lastDraw time.Time
+	spin     int
+
+	// found is the number of repositories capture has found
+	found int
+	// path, count and total describe the repository apply is setting up
+	path  string
+	count int
+	total int
+	done  int
+	// phase and percent are the last progress git reported
+	phase   string
+	percent int
+	// printed is the last quarter of each phase logged in plain mode
+	printed map[string]int
+	// logger receives git's progress in plain mode
+	logger *slog.Logger
+}
+
+// newProgressReporter returns a reporter writing to w for mode, or nil when
+// progress is not displayed
+func newProgressReporter(mode string, w io.Writer) (*progressReporter, error) {
+	switch mode {
+	case progressAuto:
+		if isTerminal(w) {
+			mode = progressBar
+		} else {
+			mode = progressPlain
+		}
+	case progressBar, progressPlain:
+	case progressNone:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("invalid progress mode %q: must be %s, %s, %s or %s", mode, progressAuto, progressBar, progressPlain, progressNone)
+	}
+	return &progressReporter{
+		w:       w,
+		bar:     mode == progressBar,
+		width:   terminalWidth(),
+		printed: map[string]int{},
+	}, nil
+}
+
+// isTerminal checks if w is a terminal that can redraw a status line
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0 && os.Getenv("TERM") != "dumb"
+}
+
+// terminalWidth returns the width of the terminal from $COLUMNS, or 80
+func terminalWidth() int {
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 0 {
+		return n
+	}
+	return 80
+}
+
+// Write writes a log message, above the status line in bar mode
+func (p *progressReporter) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+	n, err := p.w.Write(b)
+	p.draw()
+	return n, err
+}
+
+// event updates the display for an event from capture or apply
+func (p *progressReporter) event(ev gate.Event) {
+	if !p.bar {
+		p.plain(ev)
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch ev.Kind {
+	case gate.EventScan:
+		p.path = ev.Path
+		if time.Since(p.lastDraw) < progressScanInterval {
+			return
+		}
+		p.spin = (p.spin + 1) % len(spinnerFrames)
+	case gate.EventFound:
+		p.found = ev.Count
+	case gate.EventRepoStart:
+		p.path, p.count, p.total = ev.Path, ev.Count, ev.Total
+		p.phase, p.percent = "", 0
+	case gate.EventGitProgress:
+		p.phase, p.percent = ev.Phase, ev.Percent
+	case gate.EventRepoDone:
+		p.done = ev.Count
+		p.phase, p.percent = "", 0
+	}
+
+	p.clear()
+	p.draw()
+}
+
+// plain logs git's progress for an event in plain mode, at most once per
+// quarter of each phase
+func (p *progressReporter) plain(ev gate.Event) {
+	p.mu.Lock()
+	switch ev.Kind {
+	case gate.EventRepoStart:
+		clear(p.printed)
+	case gate.EventGitProgress:
+		quarter := ev.Percent / 25
+		if last, ok := p.printed[ev.Phase]; ok && quarter <= last {
+			p.mu.Unlock()
+			return
+		}
+		p.printed[ev.Phase] = quarter
+	default:
+		p.mu.Unlock()
+		return
+	}
+	p.mu.Unlock()
+
+	// Logging writes through Write, which takes the lock
+	if ev.Kind == gate.EventGitProgress {
+		p.logger.Info(fmt.Sprintf("  %s: %d%%", strings.ToLower(ev.Phase), ev.Percent), "event", "git_progress", "path", ev.Path, "phase", ev.Phase, "percent", ev.Percent)
+	}
+}
+
+// finish removes the status line once capture or apply has finished
+func (p *progressReporter) finish() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+	p.path, p.total = "", 0
+}
+
+// clear erases the status line
+func (p *progressReporter) clear() {
+	if p.status == "" {
+		return
+	}
+	fmt.Fprint(p.w, "\r\x1b[K")
+	p.status = ""
+}
+
+// draw draws the status line for the current state
+func (p *progressReporter) draw() {
+	if !p.bar || p.path == "" {
+		return
+	}
+
+	var line string
+	if p.total > 0 {
+		filled := p.done * progressBarWidth / p.total
+		bar := strings.Repeat("=", filled)
+		if filled < progressBarWidth {
+			bar += ">" + strings.Repeat(" ", progressBarWidth-filled-1)
+		}
+		line = fmt.Sprintf("[%s] %d/%d %s", bar, p.count, p.total, p.path)
+		if p.phase != "" {
+			line += fmt.Sprintf(": %s %d%%", strings.ToLower(p.phase), p.percent)
+		}
+	} else {
+		line = fmt.Sprintf("%s scanning %s (%d found)", spinnerFrames[p.spin], p.path, p.found)
+	}
+
+	// A line that wraps could not be erased, so keep it to the terminal
+	if runes := []rune(line); len(runes) > p.width-1 {
+		line = string(runes[:p.width-1])
+	}
+	fmt.Fprint(p.w, line)
+	p.status = line
+	p.lastDraw = time.Now()
+}